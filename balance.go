@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+)
+
+// Balance compares the distribution of a covariate between cases and
+// controls.
+type Balance struct {
+	Covariate    string
+	NCases       int
+	NControls    int
+	MeanCases    float64
+	MeanControls float64
+	// SMD is the standardized mean difference, i.e. the difference of the
+	// means divided by the pooled standard deviation.
+	SMD float64
+	// VarianceRatio is the variance of the cases divided by the variance of
+	// the controls.
+	VarianceRatio float64
+}
+
+// NewBalance computes the balance of c among subjects, each of which counts
+// with its weight.
+func NewBalance(c Covariate, subjects []*Subject, weights Weights) Balance {
+	var cases, controls, caseWeights, controlWeights []float64
+	for _, s := range subjects {
+		v, ok := c.Value(s)
+		if !ok {
+			continue
+		}
+		if s.Diagnosis == GK {
+			controls = append(controls, v)
+			controlWeights = append(controlWeights, weights.Of(s))
+		} else {
+			cases = append(cases, v)
+			caseWeights = append(caseWeights, weights.Of(s))
+		}
+	}
+	meanCases, varCases := weightedMeanVariance(cases, caseWeights)
+	meanControls, varControls := weightedMeanVariance(controls, controlWeights)
+	return Balance{
+		Covariate:     c.Name,
+		NCases:        len(cases),
+		NControls:     len(controls),
+		MeanCases:     meanCases,
+		MeanControls:  meanControls,
+		SMD:           (meanCases - meanControls) / math.Sqrt((varCases+varControls)/2),
+		VarianceRatio: varCases / varControls,
+	}
+}
+
+// WriteBalance writes one row per covariate and cohort. The n columns are the
+// number of distinct subjects, the other columns use the weights of the
+// cohort.
+func WriteBalance(w *csv.Writer, covariates []Covariate, cohorts []string, subjects [][]*Subject, weights []Weights) error {
+	header := []string{"Kovariate", "Kohorte", "n MS", "n GK", "Mittel MS", "Mittel GK", "SMD", "Varianzverhältnis"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, c := range covariates {
+		for i, cohort := range cohorts {
+			b := NewBalance(c, subjects[i], weights[i])
+			row := []string{
+				b.Covariate,
+				cohort,
+				fmt.Sprintf("%d", b.NCases),
+				fmt.Sprintf("%d", b.NControls),
+				float64Str(b.MeanCases),
+				float64Str(b.MeanControls),
+				float64Str(b.SMD),
+				float64Str(b.VarianceRatio),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// float64Str formats v like float64PtrStr, using "n/a" for values that are
+// not a finite number.
+func float64Str(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%f", v)
+}
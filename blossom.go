@@ -0,0 +1,583 @@
+package main
+
+// weightedEdge is an undirected edge between the vertices I and J.
+type weightedEdge struct {
+	I, J int
+	W    int64
+}
+
+// maxWeightMatching computes a maximum-weight matching of the general graph
+// given by edges using Edmonds' blossom algorithm, in the O(n^3) primal-dual
+// formulation by Galil. The vertices are numbered from 0 to the highest
+// vertex found in edges. It returns mate, where mate[v] is the vertex that v
+// is matched to, or -1 if v stays single.
+//
+// Integer weights keep all dual variables integral, so the slack
+// comparisons are exact.
+func maxWeightMatching(edges []weightedEdge) []int {
+	if len(edges) == 0 {
+		return nil
+	}
+	b := newBlossomMatcher(edges)
+	b.solve()
+	mate := make([]int, b.nvertex)
+	for v := range mate {
+		mate[v] = -1
+		if b.mate[v] >= 0 {
+			mate[v] = b.endpoint[b.mate[v]]
+		}
+	}
+	return mate
+}
+
+// blossomMatcher holds the state of maxWeightMatching. Edge k has the two
+// endpoints 2k and 2k+1, endpoint p belongs to vertex endpoint[p] and p^1 is
+// the opposite endpoint. Vertices are 0..nvertex-1, non-trivial blossoms are
+// nvertex..2*nvertex-1.
+type blossomMatcher struct {
+	edges     []weightedEdge
+	nvertex   int
+	endpoint  []int
+	neighbend [][]int
+	// mate[v] is the remote endpoint of the matched edge of v, or -1.
+	mate []int
+	// label is 0 for unlabeled, 1 for S, 2 for T, for vertices and top-level
+	// blossoms. 5 is a temporary mark used by scanBlossom.
+	label []int
+	// labelend is the endpoint through which a vertex or blossom got its
+	// label, or -1.
+	labelend         []int
+	inblossom        []int
+	blossomparent    []int
+	blossomchilds    [][]int
+	blossombase      []int
+	blossomendps     [][]int
+	bestedge         []int
+	blossombestedges [][]int
+	unusedblossoms   []int
+	dualvar          []int64
+	allowedge        []bool
+	queue            []int
+}
+
+func newBlossomMatcher(edges []weightedEdge) *blossomMatcher {
+	b := &blossomMatcher{edges: edges}
+	var maxweight int64
+	for _, e := range edges {
+		if e.I+1 > b.nvertex {
+			b.nvertex = e.I + 1
+		}
+		if e.J+1 > b.nvertex {
+			b.nvertex = e.J + 1
+		}
+		if e.W > maxweight {
+			maxweight = e.W
+		}
+	}
+	n := b.nvertex
+	b.endpoint = make([]int, 2*len(edges))
+	b.neighbend = make([][]int, n)
+	for k, e := range edges {
+		b.endpoint[2*k] = e.I
+		b.endpoint[2*k+1] = e.J
+		b.neighbend[e.I] = append(b.neighbend[e.I], 2*k+1)
+		b.neighbend[e.J] = append(b.neighbend[e.J], 2*k)
+	}
+	b.mate = fillInts(n, -1)
+	b.label = make([]int, 2*n)
+	b.labelend = fillInts(2*n, -1)
+	b.inblossom = make([]int, n)
+	b.blossomparent = fillInts(2*n, -1)
+	b.blossomchilds = make([][]int, 2*n)
+	b.blossombase = fillInts(2*n, -1)
+	b.blossomendps = make([][]int, 2*n)
+	b.bestedge = fillInts(2*n, -1)
+	b.blossombestedges = make([][]int, 2*n)
+	b.dualvar = make([]int64, 2*n)
+	b.allowedge = make([]bool, len(edges))
+	for v := 0; v < n; v++ {
+		b.inblossom[v] = v
+		b.blossombase[v] = v
+		b.dualvar[v] = maxweight
+		b.unusedblossoms = append(b.unusedblossoms, n+v)
+	}
+	return b
+}
+
+func fillInts(n, v int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+// index maps a possibly negative index into a slice of length n, like
+// Python does.
+func index(i, n int) int {
+	return ((i % n) + n) % n
+}
+
+func (b *blossomMatcher) slack(k int) int64 {
+	e := b.edges[k]
+	return b.dualvar[e.I] + b.dualvar[e.J] - 2*e.W
+}
+
+func (b *blossomMatcher) blossomLeaves(t int) []int {
+	if t < b.nvertex {
+		return []int{t}
+	}
+	var leaves []int
+	for _, c := range b.blossomchilds[t] {
+		leaves = append(leaves, b.blossomLeaves(c)...)
+	}
+	return leaves
+}
+
+// assignLabel assigns label t to the top-level blossom containing vertex w,
+// which is reached through endpoint p.
+func (b *blossomMatcher) assignLabel(w, t, p int) {
+	bw := b.inblossom[w]
+	b.label[w], b.label[bw] = t, t
+	b.labelend[w], b.labelend[bw] = p, p
+	b.bestedge[w], b.bestedge[bw] = -1, -1
+	if t == 1 {
+		b.queue = append(b.queue, b.blossomLeaves(bw)...)
+	} else if t == 2 {
+		base := b.blossombase[bw]
+		b.assignLabel(b.endpoint[b.mate[base]], 1, b.mate[base]^1)
+	}
+}
+
+// scanBlossom traces back from v and w to discover either a new blossom,
+// whose base is returned, or an augmenting path, in which case -1 is
+// returned.
+func (b *blossomMatcher) scanBlossom(v, w int) int {
+	var path []int
+	base := -1
+	for v != -1 || w != -1 {
+		bv := b.inblossom[v]
+		if b.label[bv]&4 != 0 {
+			base = b.blossombase[bv]
+			break
+		}
+		path = append(path, bv)
+		b.label[bv] = 5
+		if b.labelend[bv] == -1 {
+			v = -1
+		} else {
+			v = b.endpoint[b.labelend[bv]]
+			bv = b.inblossom[v]
+			v = b.endpoint[b.labelend[bv]]
+		}
+		if w != -1 {
+			v, w = w, v
+		}
+	}
+	for _, bv := range path {
+		b.label[bv] = 1
+	}
+	return base
+}
+
+// addBlossom constructs a new blossom with the given base, through the S-S
+// edge k.
+func (b *blossomMatcher) addBlossom(base, k int) {
+	v, w := b.edges[k].I, b.edges[k].J
+	bb := b.inblossom[base]
+	bv := b.inblossom[v]
+	bw := b.inblossom[w]
+	nb := b.unusedblossoms[len(b.unusedblossoms)-1]
+	b.unusedblossoms = b.unusedblossoms[:len(b.unusedblossoms)-1]
+	b.blossombase[nb] = base
+	b.blossomparent[nb] = -1
+	b.blossomparent[bb] = nb
+	var path, endps []int
+	for bv != bb {
+		b.blossomparent[bv] = nb
+		path = append(path, bv)
+		endps = append(endps, b.labelend[bv])
+		v = b.endpoint[b.labelend[bv]]
+		bv = b.inblossom[v]
+	}
+	path = append(path, bb)
+	reverseInts(path)
+	reverseInts(endps)
+	endps = append(endps, 2*k)
+	for bw != bb {
+		b.blossomparent[bw] = nb
+		path = append(path, bw)
+		endps = append(endps, b.labelend[bw]^1)
+		w = b.endpoint[b.labelend[bw]]
+		bw = b.inblossom[w]
+	}
+	b.blossomchilds[nb] = path
+	b.blossomendps[nb] = endps
+	b.label[nb] = 1
+	b.labelend[nb] = b.labelend[bb]
+	b.dualvar[nb] = 0
+	for _, leaf := range b.blossomLeaves(nb) {
+		if b.label[b.inblossom[leaf]] == 2 {
+			// This T-vertex now turns into an S-vertex because it
+			// becomes part of an S-blossom.
+			b.queue = append(b.queue, leaf)
+		}
+		b.inblossom[leaf] = nb
+	}
+	bestedgeto := fillInts(2*b.nvertex, -1)
+	for _, child := range path {
+		var nblists [][]int
+		if b.blossombestedges[child] == nil {
+			for _, leaf := range b.blossomLeaves(child) {
+				var nblist []int
+				for _, p := range b.neighbend[leaf] {
+					nblist = append(nblist, p/2)
+				}
+				nblists = append(nblists, nblist)
+			}
+		} else {
+			nblists = [][]int{b.blossombestedges[child]}
+		}
+		for _, nblist := range nblists {
+			for _, k := range nblist {
+				i, j := b.edges[k].I, b.edges[k].J
+				if b.inblossom[j] == nb {
+					i, j = j, i
+				}
+				bj := b.inblossom[j]
+				if bj != nb && b.label[bj] == 1 && (bestedgeto[bj] == -1 || b.slack(k) < b.slack(bestedgeto[bj])) {
+					bestedgeto[bj] = k
+				}
+			}
+		}
+		b.blossombestedges[child] = nil
+		b.bestedge[child] = -1
+	}
+	b.blossombestedges[nb] = []int{}
+	for _, k := range bestedgeto {
+		if k != -1 {
+			b.blossombestedges[nb] = append(b.blossombestedges[nb], k)
+		}
+	}
+	b.bestedge[nb] = -1
+	for _, k := range b.blossombestedges[nb] {
+		if b.bestedge[nb] == -1 || b.slack(k) < b.slack(b.bestedge[nb]) {
+			b.bestedge[nb] = k
+		}
+	}
+}
+
+// expandBlossom turns the sub-blossoms of blossom t back into top-level
+// blossoms.
+func (b *blossomMatcher) expandBlossom(t int, endstage bool) {
+	for _, s := range b.blossomchilds[t] {
+		b.blossomparent[s] = -1
+		if s < b.nvertex {
+			b.inblossom[s] = s
+		} else if endstage && b.dualvar[s] == 0 {
+			b.expandBlossom(s, endstage)
+		} else {
+			for _, leaf := range b.blossomLeaves(s) {
+				b.inblossom[leaf] = s
+			}
+		}
+	}
+	if !endstage && b.label[t] == 2 {
+		// Relabel the sub-blossoms on the even-length path from the entry
+		// child to the base as alternating T and S.
+		childs, endps := b.blossomchilds[t], b.blossomendps[t]
+		n := len(childs)
+		entrychild := b.inblossom[b.endpoint[b.labelend[t]^1]]
+		j := indexOf(childs, entrychild)
+		var jstep, endptrick int
+		if j&1 != 0 {
+			j -= n
+			jstep = 1
+			endptrick = 0
+		} else {
+			jstep = -1
+			endptrick = 1
+		}
+		p := b.labelend[t]
+		for j != 0 {
+			b.label[b.endpoint[p^1]] = 0
+			b.label[b.endpoint[endps[index(j-endptrick, n)]^endptrick^1]] = 0
+			b.assignLabel(b.endpoint[p^1], 2, p)
+			b.allowedge[endps[index(j-endptrick, n)]/2] = true
+			j += jstep
+			p = endps[index(j-endptrick, n)] ^ endptrick
+			b.allowedge[p/2] = true
+			j += jstep
+		}
+		bv := childs[index(j, n)]
+		b.label[b.endpoint[p^1]], b.label[bv] = 2, 2
+		b.labelend[b.endpoint[p^1]], b.labelend[bv] = p, p
+		b.bestedge[bv] = -1
+		j += jstep
+		for childs[index(j, n)] != entrychild {
+			bv = childs[index(j, n)]
+			if b.label[bv] == 1 {
+				j += jstep
+				continue
+			}
+			labeled := -1
+			for _, leaf := range b.blossomLeaves(bv) {
+				if b.label[leaf] != 0 {
+					labeled = leaf
+					break
+				}
+			}
+			if labeled != -1 {
+				b.label[labeled] = 0
+				b.label[b.endpoint[b.mate[b.blossombase[bv]]]] = 0
+				b.assignLabel(labeled, 2, b.labelend[labeled])
+			}
+			j += jstep
+		}
+	}
+	b.label[t], b.labelend[t] = -1, -1
+	b.blossomchilds[t], b.blossomendps[t] = nil, nil
+	b.blossombase[t] = -1
+	b.blossombestedges[t] = nil
+	b.bestedge[t] = -1
+	b.unusedblossoms = append(b.unusedblossoms, t)
+}
+
+// augmentBlossom swaps matched and unmatched edges along the path from
+// vertex v to the base of blossom t, making v the new base.
+func (b *blossomMatcher) augmentBlossom(t, v int) {
+	s := v
+	for b.blossomparent[s] != t {
+		s = b.blossomparent[s]
+	}
+	if s >= b.nvertex {
+		b.augmentBlossom(s, v)
+	}
+	childs, endps := b.blossomchilds[t], b.blossomendps[t]
+	n := len(childs)
+	i := indexOf(childs, s)
+	j := i
+	var jstep, endptrick int
+	if i&1 != 0 {
+		j -= n
+		jstep = 1
+		endptrick = 0
+	} else {
+		jstep = -1
+		endptrick = 1
+	}
+	for j != 0 {
+		j += jstep
+		s = childs[index(j, n)]
+		p := endps[index(j-endptrick, n)] ^ endptrick
+		if s >= b.nvertex {
+			b.augmentBlossom(s, b.endpoint[p])
+		}
+		j += jstep
+		s = childs[index(j, n)]
+		if s >= b.nvertex {
+			b.augmentBlossom(s, b.endpoint[p^1])
+		}
+		b.mate[b.endpoint[p]] = p ^ 1
+		b.mate[b.endpoint[p^1]] = p
+	}
+	b.blossomchilds[t] = append(append([]int{}, childs[i:]...), childs[:i]...)
+	b.blossomendps[t] = append(append([]int{}, endps[i:]...), endps[:i]...)
+	b.blossombase[t] = b.blossombase[b.blossomchilds[t][0]]
+}
+
+// augmentMatching swaps matched and unmatched edges along the augmenting
+// path through edge k.
+func (b *blossomMatcher) augmentMatching(k int) {
+	v, w := b.edges[k].I, b.edges[k].J
+	for _, sp := range [][2]int{{v, 2*k + 1}, {w, 2 * k}} {
+		s, p := sp[0], sp[1]
+		for {
+			bs := b.inblossom[s]
+			if bs >= b.nvertex {
+				b.augmentBlossom(bs, s)
+			}
+			b.mate[s] = p
+			if b.labelend[bs] == -1 {
+				break
+			}
+			t := b.endpoint[b.labelend[bs]]
+			bt := b.inblossom[t]
+			s = b.endpoint[b.labelend[bt]]
+			j := b.endpoint[b.labelend[bt]^1]
+			if bt >= b.nvertex {
+				b.augmentBlossom(bt, j)
+			}
+			b.mate[j] = b.labelend[bt]
+			p = b.labelend[bt] ^ 1
+		}
+	}
+}
+
+func (b *blossomMatcher) solve() {
+	n := b.nvertex
+	// Each stage finds an augmenting path, so there are at most n stages.
+	for stage := 0; stage < n; stage++ {
+		for i := range b.label {
+			b.label[i] = 0
+			b.bestedge[i] = -1
+		}
+		for i := n; i < 2*n; i++ {
+			b.blossombestedges[i] = nil
+		}
+		for i := range b.allowedge {
+			b.allowedge[i] = false
+		}
+		b.queue = b.queue[:0]
+		for v := 0; v < n; v++ {
+			if b.mate[v] == -1 && b.label[b.inblossom[v]] == 0 {
+				b.assignLabel(v, 1, -1)
+			}
+		}
+		augmented := false
+		for {
+			for len(b.queue) > 0 && !augmented {
+				v := b.queue[len(b.queue)-1]
+				b.queue = b.queue[:len(b.queue)-1]
+				for _, p := range b.neighbend[v] {
+					k := p / 2
+					w := b.endpoint[p]
+					if b.inblossom[v] == b.inblossom[w] {
+						continue
+					}
+					var kslack int64
+					if !b.allowedge[k] {
+						kslack = b.slack(k)
+						if kslack <= 0 {
+							b.allowedge[k] = true
+						}
+					}
+					if b.allowedge[k] {
+						if b.label[b.inblossom[w]] == 0 {
+							b.assignLabel(w, 2, p^1)
+						} else if b.label[b.inblossom[w]] == 1 {
+							base := b.scanBlossom(v, w)
+							if base >= 0 {
+								b.addBlossom(base, k)
+							} else {
+								b.augmentMatching(k)
+								augmented = true
+								break
+							}
+						} else if b.label[w] == 0 {
+							b.label[w] = 2
+							b.labelend[w] = p ^ 1
+						}
+					} else if b.label[b.inblossom[w]] == 1 {
+						bv := b.inblossom[v]
+						if b.bestedge[bv] == -1 || kslack < b.slack(b.bestedge[bv]) {
+							b.bestedge[bv] = k
+						}
+					} else if b.label[w] == 0 {
+						if b.bestedge[w] == -1 || kslack < b.slack(b.bestedge[w]) {
+							b.bestedge[w] = k
+						}
+					}
+				}
+			}
+			if augmented {
+				break
+			}
+
+			// No augmenting path with the current tight edges, so update
+			// the dual variables by the smallest of four kinds of deltas.
+			deltatype := 1
+			delta := b.dualvar[0]
+			for v := 1; v < n; v++ {
+				if b.dualvar[v] < delta {
+					delta = b.dualvar[v]
+				}
+			}
+			deltaedge, deltablossom := -1, -1
+			for v := 0; v < n; v++ {
+				if b.label[b.inblossom[v]] == 0 && b.bestedge[v] != -1 {
+					if d := b.slack(b.bestedge[v]); d < delta {
+						delta = d
+						deltatype = 2
+						deltaedge = b.bestedge[v]
+					}
+				}
+			}
+			for t := 0; t < 2*n; t++ {
+				if b.blossomparent[t] == -1 && b.label[t] == 1 && b.bestedge[t] != -1 {
+					if d := b.slack(b.bestedge[t]) / 2; d < delta {
+						delta = d
+						deltatype = 3
+						deltaedge = b.bestedge[t]
+					}
+				}
+			}
+			for t := n; t < 2*n; t++ {
+				if b.blossombase[t] >= 0 && b.blossomparent[t] == -1 && b.label[t] == 2 && b.dualvar[t] < delta {
+					delta = b.dualvar[t]
+					deltatype = 4
+					deltablossom = t
+				}
+			}
+			for v := 0; v < n; v++ {
+				switch b.label[b.inblossom[v]] {
+				case 1:
+					b.dualvar[v] -= delta
+				case 2:
+					b.dualvar[v] += delta
+				}
+			}
+			for t := n; t < 2*n; t++ {
+				if b.blossombase[t] >= 0 && b.blossomparent[t] == -1 {
+					switch b.label[t] {
+					case 1:
+						b.dualvar[t] += delta
+					case 2:
+						b.dualvar[t] -= delta
+					}
+				}
+			}
+			if deltatype == 1 {
+				// The dual variables of the single vertices reached zero,
+				// the matching is optimal.
+				break
+			} else if deltatype == 2 {
+				b.allowedge[deltaedge] = true
+				i, j := b.edges[deltaedge].I, b.edges[deltaedge].J
+				if b.label[b.inblossom[i]] == 0 {
+					i, j = j, i
+				}
+				b.queue = append(b.queue, i)
+			} else if deltatype == 3 {
+				b.allowedge[deltaedge] = true
+				b.queue = append(b.queue, b.edges[deltaedge].I)
+			} else if deltatype == 4 {
+				b.expandBlossom(deltablossom, false)
+			}
+		}
+		if !augmented {
+			break
+		}
+		// Expand S-blossoms whose dual variable dropped to zero.
+		for t := n; t < 2*n; t++ {
+			if b.blossomparent[t] == -1 && b.blossombase[t] >= 0 && b.label[t] == 1 && b.dualvar[t] == 0 {
+				b.expandBlossom(t, true)
+			}
+		}
+	}
+}
+
+func indexOf(s []int, v int) int {
+	for i, x := range s {
+		if x == v {
+			return i
+		}
+	}
+	panic("bug: value not found")
+}
+
+func reverseInts(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func Test_maxWeightMatching(t *testing.T) {
+	tests := []struct {
+		Edges []weightedEdge
+		Want  []int
+	}{
+		{
+			Edges: []weightedEdge{{0, 1, 1}},
+			Want:  []int{1, 0},
+		},
+		{
+			// Greedy would take the heaviest edge 1-2 and stop there.
+			Edges: []weightedEdge{{0, 1, 5}, {1, 2, 6}, {2, 3, 5}},
+			Want:  []int{1, 0, 3, 2},
+		},
+		{
+			// A blossom 1-2-3 that has to be contracted and expanded again.
+			Edges: []weightedEdge{{0, 1, 8}, {0, 2, 9}, {1, 2, 10}, {2, 3, 7}},
+			Want:  []int{1, 0, 3, 2},
+		},
+		{
+			Edges: []weightedEdge{{0, 1, 9}, {0, 2, 8}, {1, 2, 10}, {0, 3, 5}, {3, 4, 4}, {0, 5, 3}},
+			Want:  []int{5, 2, 1, 4, 3, 0},
+		},
+	}
+	for i, test := range tests {
+		got := maxWeightMatching(test.Edges)
+		if len(got) != len(test.Want) {
+			t.Errorf("test %d: want %v, got %v", i, test.Want, got)
+			continue
+		}
+		for v := range got {
+			if got[v] != test.Want[v] {
+				t.Errorf("test %d: want %v, got %v", i, test.Want, got)
+				break
+			}
+		}
+	}
+}
+
+func Test_maxWeightMatching_BruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		n := 2 + r.Intn(7)
+		var edges []weightedEdge
+		weights := map[[2]int]int64{}
+		for a := 0; a < n; a++ {
+			for b := a + 1; b < n; b++ {
+				if r.Intn(3) == 0 {
+					continue
+				}
+				w := int64(1 + r.Intn(20))
+				edges = append(edges, weightedEdge{a, b, w})
+				weights[[2]int{a, b}] = w
+				weights[[2]int{b, a}] = w
+			}
+		}
+		if len(edges) == 0 {
+			continue
+		}
+		mate := maxWeightMatching(edges)
+		var got int64
+		for v, u := range mate {
+			if u == -1 {
+				continue
+			}
+			if mate[u] != v {
+				t.Fatalf("test %d: mate is not symmetric: %v", i, mate)
+			}
+			w, ok := weights[[2]int{v, u}]
+			if !ok {
+				t.Fatalf("test %d: %d-%d is not an edge", i, v, u)
+			}
+			if v < u {
+				got += w
+			}
+		}
+		want := bruteForceMatching(len(mate), weights, make([]bool, len(mate)), 0)
+		if got != want {
+			t.Errorf("test %d: %v: want weight %d, got %d", i, edges, want, got)
+		}
+	}
+}
+
+// bruteForceMatching returns the weight of the heaviest matching among the
+// vertices from v onwards that are not used yet.
+func bruteForceMatching(n int, weights map[[2]int]int64, used []bool, v int) int64 {
+	for v < n && used[v] {
+		v++
+	}
+	if v >= n {
+		return 0
+	}
+	used[v] = true
+	best := bruteForceMatching(n, weights, used, v+1)
+	for u := v + 1; u < n; u++ {
+		w, ok := weights[[2]int{v, u}]
+		if used[u] || !ok {
+			continue
+		}
+		used[u] = true
+		if total := w + bruteForceMatching(n, weights, used, v+1); total > best {
+			best = total
+		}
+		used[u] = false
+	}
+	used[v] = false
+	return best
+}
+
+func Test_Subjects_MatchOptimal_infinite(t *testing.T) {
+	s := Subjects{{}, {}, {}, {}}
+	scores := map[[2]*Subject]float64{
+		{s[0], s[1]}: 1,
+		{s[1], s[2]}: math.Inf(1),
+		{s[2], s[3]}: 1,
+	}
+	matches := s.MatchOptimal(func(a, b *Subject) float64 {
+		return scores[[2]*Subject{a, b}]
+	})
+	if len(matches) != 1 || matches[0].A != s[1] || matches[0].B != s[2] || !math.IsInf(matches[0].Score, 1) {
+		t.Errorf("want only the infinite pair, got %+v", matches)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+func CategoryByName(name string) (Category, error) {
+	for _, c := range Categories {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Category{}, fmt.Errorf("Unknown category: %s", name)
+}
+
+// Category is a categorical property of a subject.
+type Category struct {
+	Name  string
+	Value func(s *Subject) string
+}
+
+var Categories = []Category{
+	{"Gender", func(s *Subject) string {
+		return string(s.Gender)
+	}},
+	{"Diagnosis", func(s *Subject) string {
+		return string(s.Diagnosis)
+	}},
+	{"Nikotinabusus", func(s *Subject) string {
+		return string(s.Nikotinabusus)
+	}},
+	{"TherapyGroup", func(s *Subject) string {
+		return string(s.TherapyGroup())
+	}},
+	{"IgG", func(s *Subject) string {
+		return s.IgG.String()
+	}},
+	{"IgM", func(s *Subject) string {
+		return s.IgM.String()
+	}},
+	{"ANA", func(s *Subject) string {
+		return s.ANA.String()
+	}},
+}
+
+// ParseStrata parses a comma separated list of category names. An empty
+// string results in no strata.
+func ParseStrata(val string) (Strata, error) {
+	var strata Strata
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, err := CategoryByName(name)
+		if err != nil {
+			return nil, err
+		}
+		strata = append(strata, c)
+	}
+	return strata, nil
+}
+
+// Strata are the categories that matched subjects must agree on exactly.
+type Strata []Category
+
+// Key returns the stratum of s, e.g. "w / ja" for Gender,Nikotinabusus.
+func (st Strata) Key(s *Subject) string {
+	if len(st) == 0 {
+		return "alle"
+	}
+	var vals []string
+	for _, c := range st {
+		vals = append(vals, c.Value(s))
+	}
+	return strings.Join(vals, " / ")
+}
+
+func (st Strata) String() string {
+	var names []string
+	for _, c := range st {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, " / ")
+}
+
+// reject returns the reason why a and b belong to different strata, or "" if
+// they are in the same one.
+func (st Strata) reject(a, b *Subject) string {
+	for _, c := range st {
+		if c.Value(a) != c.Value(b) {
+			return "different " + c.Name
+		}
+	}
+	return ""
+}
+
+// Restrict returns a score function for Subjects.Match that gives a score of
+// 0 to subjects from different strata.
+func (st Strata) Restrict(score func(a, b *Subject) float64) func(a, b *Subject) float64 {
+	return func(a, b *Subject) float64 {
+		if st.reject(a, b) != "" {
+			return 0
+		}
+		return score(a, b)
+	}
+}
+
+// WriteStrata writes the number of subjects and matched subjects of each
+// group per stratum, in the order the strata first appear in subjects.
+func WriteStrata(w *csv.Writer, st Strata, subjects, matched []*Subject, groups []string, group func(*Subject) string) error {
+	header := []string{st.String()}
+	for _, g := range groups {
+		header = append(header, "n "+g, "gematcht "+g)
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	isMatched := map[*Subject]bool{}
+	for _, s := range matched {
+		isMatched[s] = true
+	}
+	type count struct {
+		All     int
+		Matched int
+	}
+	var keys []string
+	counts := map[string]map[string]*count{}
+	for _, s := range subjects {
+		key := st.Key(s)
+		if counts[key] == nil {
+			keys = append(keys, key)
+			counts[key] = map[string]*count{}
+			for _, g := range groups {
+				counts[key][g] = &count{}
+			}
+		}
+		c := counts[key][group(s)]
+		if c == nil {
+			continue
+		}
+		c.All++
+		if isMatched[s] {
+			c.Matched++
+		}
+	}
+	for _, key := range keys {
+		row := []string{key}
+		for _, g := range groups {
+			c := counts[key][g]
+			row = append(row, fmt.Sprintf("%d", c.All), fmt.Sprintf("%d", c.Matched))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
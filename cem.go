@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseCoarsenings parses a comma separated list of covariate=width pairs,
+// e.g. "Age=5, SickDuration=12". A colon works as well, e.g. "Age:5".
+func ParseCoarsenings(val string) ([]Coarsening, error) {
+	var coarsenings []Coarsening
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.IndexAny(part, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("Bad coarsening: %s", part)
+		}
+		c, err := CovariateByName(strings.TrimSpace(part[:i]))
+		if err != nil {
+			return nil, err
+		}
+		width, err := strconv.ParseFloat(strings.TrimSpace(part[i+1:]), 64)
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("Bad coarsening width: %s", part)
+		}
+		coarsenings = append(coarsenings, Coarsening{Covariate: c, Width: width})
+	}
+	return coarsenings, nil
+}
+
+// Coarsening cuts a covariate into bins of the given width, starting at 0.
+type Coarsening struct {
+	Covariate Covariate
+	Width     float64
+}
+
+// Bin returns the bin of s, e.g. "Age [30,35)".
+func (c Coarsening) Bin(s *Subject) (string, bool) {
+	v, ok := c.Covariate.Value(s)
+	if !ok {
+		return "", false
+	}
+	lower := math.Floor(v/c.Width) * c.Width
+	return fmt.Sprintf("%s [%g,%g)", c.Covariate.Name, lower, lower+c.Width), true
+}
+
+// matchCEM performs coarsened exact matching. Subjects are grouped by their
+// stratum and the bins of the coarsened covariates, and every group that
+// contains controls as well as cases becomes a matched set.
+func matchCEM(controls, cases []*Subject, opts MatchOptions) (MatchedSets, []Unmatched) {
+	var (
+		keys      []string
+		groups    = map[string]*MatchedSet{}
+		unmatched []Unmatched
+	)
+	for _, s := range append(append([]*Subject{}, controls...), cases...) {
+		key := opts.Strata.Key(s)
+		missing := ""
+		for _, c := range opts.Coarsenings {
+			bin, ok := c.Bin(s)
+			if !ok {
+				missing = c.Covariate.Name
+				break
+			}
+			key += " / " + bin
+		}
+		if missing != "" {
+			unmatched = append(unmatched, Unmatched{Subject: s, Reason: "missing " + missing})
+			continue
+		}
+		set := groups[key]
+		if set == nil {
+			keys = append(keys, key)
+			set = &MatchedSet{Stratum: key}
+			groups[key] = set
+		}
+		if s.Diagnosis == GK {
+			set.Controls = append(set.Controls, s)
+		} else {
+			set.Cases = append(set.Cases, s)
+		}
+	}
+	var sets MatchedSets
+	for _, key := range keys {
+		set := groups[key]
+		if len(set.Controls) > 0 && len(set.Cases) > 0 {
+			sets = append(sets, *set)
+			continue
+		}
+		for _, s := range set.Controls {
+			unmatched = append(unmatched, Unmatched{Subject: s, Reason: "no case in stratum " + key})
+		}
+		for _, s := range set.Cases {
+			unmatched = append(unmatched, Unmatched{Subject: s, Reason: "no control in stratum " + key})
+		}
+	}
+	return sets, unmatched
+}
+
+// WriteSetWeights writes the size and the weights of each matched set.
+func WriteSetWeights(w *csv.Writer, sets MatchedSets) error {
+	header := []string{"Set", "Stratum", "Kontrollen", "Fälle", "Gewicht Kontrolle", "Gewicht Fall"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	controlWeights := sets.ControlWeights()
+	for i, set := range sets {
+		row := []string{
+			fmt.Sprintf("%d", i+1),
+			set.Stratum,
+			fmt.Sprintf("%d", len(set.Controls)),
+			fmt.Sprintf("%d", len(set.Cases)),
+			float64Str(controlWeights[i]),
+			float64Str(1),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
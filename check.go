@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	minAge = 0
+	maxAge = 120
+)
+
+// CheckSubjects runs the semantic checks that go beyond parsing single
+// cells, e.g. an age of onset after the age at sampling, or duplicate IDs.
+func CheckSubjects(subjects Subjects, mapping Mapping) ValidationErrors {
+	var errs ValidationErrors
+	add := func(s *Subject, field, value, expected, msg string) {
+		errs = append(errs, ValidationError{
+			Line:     s.Line,
+			Column:   mapping.ColumnName(field),
+			Field:    field,
+			Value:    value,
+			Expected: expected,
+			Message:  msg,
+		})
+	}
+	probeNumbers := map[string]*Subject{}
+	labBerlinNumbers := map[string]*Subject{}
+	for _, s := range subjects {
+		if s.Age <= minAge || s.Age > maxAge {
+			add(s, "Age", numberStr(s.Age), fmt.Sprintf("> %d und <= %d", minAge, maxAge), "age out of range")
+		}
+		if s.AgeEM != nil && *s.AgeEM > s.Age {
+			add(s, "AgeEM", numberStr(*s.AgeEM), fmt.Sprintf("<= Alter (PE) %s", numberStr(s.Age)), "age of onset after age at sampling")
+		}
+		if s.SickDuration != nil && *s.SickDuration < 0 {
+			add(s, "SickDuration", numberStr(*s.SickDuration), ">= 0", "negative sick duration")
+		}
+		if s.EDSS != nil {
+			if edss := *s.EDSS; edss < 0 || edss > 10 || math.Mod(edss*2, 1) != 0 {
+				add(s, "EDSS", numberStr(edss), "0 bis 10 in Schritten von 0,5", "invalid EDSS")
+			}
+		}
+		if s.ProbeNumber != "" {
+			if other := probeNumbers[s.ProbeNumber]; other != nil {
+				add(s, "ProbeNumber", s.ProbeNumber, "eindeutig", fmt.Sprintf("duplicate of line %d", other.Line))
+			} else {
+				probeNumbers[s.ProbeNumber] = s
+			}
+		}
+		if s.LabBerlinNumber != "" {
+			if other := labBerlinNumbers[s.LabBerlinNumber]; other != nil {
+				add(s, "LabBerlinNumber", s.LabBerlinNumber, "eindeutig", fmt.Sprintf("duplicate of line %d", other.Line))
+			} else {
+				labBerlinNumbers[s.LabBerlinNumber] = s
+			}
+		}
+		if s.Diagnosis == GK {
+			msOnly := []struct {
+				Field string
+				Value *float64
+			}{
+				{"AgeEM", s.AgeEM},
+				{"SickDuration", s.SickDuration},
+				{"EDSS", s.EDSS},
+				{"NumRelapse", s.NumRelapse},
+			}
+			for _, f := range msOnly {
+				if f.Value != nil {
+					add(s, f.Field, numberStr(*f.Value), "leer bei GK", "MS-only field set for a control")
+				}
+			}
+			if s.BaseMedication == Yes {
+				add(s, "BaseMedication", string(s.BaseMedication), "leer bei GK", "MS-only field set for a control")
+			}
+			if s.EscalationTherapy == Yes {
+				add(s, "EscalationTherapy", string(s.EscalationTherapy), "leer bei GK", "MS-only field set for a control")
+			}
+		}
+		if s.Birthday != nil && s.SamplingDate != nil && s.SamplingDate.Before(*s.Birthday) {
+			add(s, "SamplingDate", dateStr(s.SamplingDate), "nach Geburtsdatum "+dateStr(s.Birthday), "sampling date before birth")
+		}
+		if s.BaseMedication == Yes && s.EscalationTherapy == Yes {
+			add(s, "EscalationTherapy", string(s.EscalationTherapy), "nicht zusammen mit Basismedikation", "both base medication and escalation therapy")
+		}
+	}
+	for _, m := range AgeMismatches(subjects) {
+		expected := fmt.Sprintf("%s (aus Datum berechnet)", numberStr(math.Floor(m.Computed)))
+		add(m.Subject, m.Field, numberStr(m.Sheet), expected, "age differs from the one computed from dates")
+	}
+	return errs
+}
+
+func numberStr(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// runValidate implements the validate subcommand. It reads and checks the
+// input without writing any output files, and exits non-zero if anything is
+// wrong.
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	sheet := fs.String("sheet", "", "Worksheet to read from an .xlsx input file, defaults to the first one")
+	mappingFile := fs.String("mapping", "", "JSON file that maps input columns to subject fields, defaults to the built-in mapping")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "./main validate [flags] <input.csv|input.xlsx>\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	fs.Parse(args)
+	inputFile := fs.Arg(0)
+	if inputFile == "" {
+		fs.Usage()
+	}
+	mapping := DefaultMapping
+	if *mappingFile != "" {
+		var err error
+		if mapping, err = ReadMapping(*mappingFile); err != nil {
+			fatalf("%s", err)
+		}
+	}
+	subjects, warnings, err := readSubjects(inputFile, *sheet, mapping)
+	if len(warnings) > 0 {
+		fmt.Printf("%d warnings:\n", len(warnings))
+		warnings.WriteTable(os.Stdout)
+	}
+	errs, ok := err.(ValidationErrors)
+	if err != nil && !ok {
+		fatalf("readSubjects: %s", err)
+	}
+	errs = append(errs, CheckSubjects(subjects, mapping)...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	if len(errs) > 0 {
+		errs.WriteTable(os.Stdout)
+		fatalf("%d problems found", len(errs))
+	}
+	fmt.Printf("%d subjects, no problems found\n", len(subjects))
+}
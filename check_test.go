@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func Test_CheckSubjects(t *testing.T) {
+	f := func(v float64) *float64 {
+		return &v
+	}
+	tests := []struct {
+		Subjects Subjects
+		Want     []string
+	}{
+		{
+			Subjects: Subjects{
+				{Line: 2, ProbeNumber: "1", Diagnosis: RRMS, Age: 30, AgeEM: f(25), SickDuration: f(60), EDSS: f(3.5), BaseMedication: Yes},
+				{Line: 3, ProbeNumber: "2", Diagnosis: GK, Age: 40},
+			},
+		},
+		{
+			Subjects: Subjects{
+				{Line: 2, ProbeNumber: "1", Diagnosis: RRMS, Age: 130},
+				{Line: 3, ProbeNumber: "2", Diagnosis: RRMS, Age: 30, AgeEM: f(35), SickDuration: f(-1)},
+				{Line: 4, ProbeNumber: "3", Diagnosis: RRMS, Age: 30, EDSS: f(3.3)},
+				{Line: 5, ProbeNumber: "4", Diagnosis: RRMS, Age: 30, EDSS: f(10.5)},
+			},
+			Want: []string{
+				"line 2, column Alter (PE): age out of range",
+				"line 3, column Alter (EM): age of onset after age at sampling",
+				"line 3, column Erkrankungsdauer (Monate): negative sick duration",
+				"line 4, column EDSS: invalid EDSS",
+				"line 5, column EDSS: invalid EDSS",
+			},
+		},
+		{
+			Subjects: Subjects{
+				{Line: 2, ProbeNumber: "1", LabBerlinNumber: "A", Diagnosis: RRMS, Age: 30},
+				{Line: 3, ProbeNumber: "1", LabBerlinNumber: "B", Diagnosis: RRMS, Age: 30},
+				{Line: 4, ProbeNumber: "2", LabBerlinNumber: "B", Diagnosis: RRMS, Age: 30},
+			},
+			Want: []string{
+				"line 3, column Probennummer: duplicate of line 2",
+				"line 4, column Labor- Berlin Nr.: duplicate of line 3",
+			},
+		},
+		{
+			Subjects: Subjects{
+				{Line: 2, ProbeNumber: "1", Diagnosis: GK, Age: 30, EDSS: f(0), BaseMedication: Yes, EscalationTherapy: Yes},
+			},
+			Want: []string{
+				"line 2, column EDSS: MS-only field set for a control",
+				"line 2, column Basismedikation: MS-only field set for a control",
+				"line 2, column Eskalationstherapie: MS-only field set for a control",
+				"line 2, column Eskalationstherapie: both base medication and escalation therapy",
+			},
+		},
+	}
+	for i, test := range tests {
+		errs := CheckSubjects(test.Subjects, DefaultMapping)
+		if len(errs) != len(test.Want) {
+			t.Errorf("test %d: want %d errors, got %v", i, len(test.Want), errs)
+			continue
+		}
+		for j, err := range errs {
+			if err.Error() != test.Want[j] {
+				t.Errorf("test %d: error %d: want %q, got %q", i, j, test.Want[j], err)
+			}
+		}
+	}
+}
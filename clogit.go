@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+)
+
+// ConditionalLogisticModel is a conditional logistic regression of being a
+// case on covariates within the matched sets. Unlike FitLogistic it has no
+// intercept, the sets take its place.
+type ConditionalLogisticModel struct {
+	Covariates   []Covariate
+	Coefficients []float64
+	StdErrors    []float64
+	// LogLikelihood is the conditional log likelihood of the fit,
+	// NullLogLikelihood the one with all coefficients 0.
+	LogLikelihood     float64
+	NullLogLikelihood float64
+	// N is the number of subjects and Sets the number of matched sets that
+	// contributed, i.e. that have a case and a control with complete
+	// covariates.
+	N          int
+	Sets       int
+	Iterations int
+}
+
+// clogitSet is a matched set with the covariates centered on their mean in
+// the set. The likelihood does not change, but exp stays in range.
+type clogitSet struct {
+	x     [][]float64
+	cases int
+	// caseSum is the sum of the covariates of the cases.
+	caseSum []float64
+}
+
+// FitConditionalLogistic fits a conditional logistic regression of being a
+// case on covariates using Newton-Raphson. Subjects with a missing
+// covariate are left out of their set.
+func FitConditionalLogistic(sets MatchedSets, covariates []Covariate) (*ConditionalLogisticModel, error) {
+	k := len(covariates)
+	if k == 0 {
+		return nil, fmt.Errorf("no covariates")
+	}
+	m := &ConditionalLogisticModel{Covariates: covariates}
+	var data []clogitSet
+	for _, set := range sets {
+		var cases, controls [][]float64
+		for _, group := range []struct {
+			Subjects []*Subject
+			Rows     *[][]float64
+		}{{set.Cases, &cases}, {set.Controls, &controls}} {
+			for _, s := range group.Subjects {
+				row := make([]float64, k)
+				ok := true
+				for j, c := range covariates {
+					if row[j], ok = c.Value(s); !ok {
+						break
+					}
+				}
+				if ok {
+					*group.Rows = append(*group.Rows, row)
+				}
+			}
+		}
+		if len(cases) == 0 || len(controls) == 0 {
+			continue
+		}
+		d := clogitSet{x: append(cases, controls...), cases: len(cases), caseSum: make([]float64, k)}
+		mean := make([]float64, k)
+		for _, row := range d.x {
+			for j, v := range row {
+				mean[j] += v / float64(len(d.x))
+			}
+		}
+		for i, row := range d.x {
+			for j := range row {
+				row[j] -= mean[j]
+				if i < d.cases {
+					d.caseSum[j] += row[j]
+				}
+			}
+		}
+		data = append(data, d)
+		m.N += len(d.x)
+		m.Sets++
+	}
+	if m.Sets == 0 {
+		return nil, fmt.Errorf("no matched sets with complete covariates")
+	}
+	const (
+		maxIterations = 50
+		tolerance     = 1e-8
+	)
+	beta := make([]float64, k)
+	m.NullLogLikelihood, _, _ = clogitLikelihood(data, beta)
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		_, gradient, information := clogitLikelihood(data, beta)
+		inv, err := invert(information)
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %s", iteration, err)
+		}
+		step := mulVec(inv, gradient)
+		maxStep := 0.0
+		for j := range beta {
+			beta[j] += step[j]
+			maxStep = math.Max(maxStep, math.Abs(step[j]))
+		}
+		if maxStep < tolerance {
+			ll, _, information := clogitLikelihood(data, beta)
+			inv, err := invert(information)
+			if err != nil {
+				return nil, err
+			}
+			m.Coefficients = beta
+			for j := range beta {
+				m.StdErrors = append(m.StdErrors, math.Sqrt(inv[j][j]))
+			}
+			m.LogLikelihood = ll
+			m.Iterations = iteration
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no convergence after %d iterations, case status may be separated by the covariates", maxIterations)
+}
+
+// clogitLikelihood returns the conditional log likelihood at beta with its
+// gradient and the observed information matrix. The denominator of each set,
+// the sum over all ways to pick as many subjects as there are cases, is
+// computed with the recursion of Gail, Lubin and Rubinstein (1981) together
+// with its derivatives.
+func clogitLikelihood(data []clogitSet, beta []float64) (ll float64, gradient []float64, information [][]float64) {
+	k := len(beta)
+	gradient = make([]float64, k)
+	information = newMatrix(k, k)
+	for _, d := range data {
+		// b[c], db[c] and d2b[c] are the denominator for c picks among the
+		// subjects seen so far and its first and second derivatives.
+		b := make([]float64, d.cases+1)
+		db := make([][]float64, d.cases+1)
+		d2b := make([][][]float64, d.cases+1)
+		for c := range b {
+			db[c] = make([]float64, k)
+			d2b[c] = newMatrix(k, k)
+		}
+		b[0] = 1
+		for _, x := range d.x {
+			r := math.Exp(dot(x, beta))
+			for c := d.cases; c >= 1; c-- {
+				for i := 0; i < k; i++ {
+					for j := 0; j < k; j++ {
+						d2b[c][i][j] += r * (d2b[c-1][i][j] + x[i]*db[c-1][j] + db[c-1][i]*x[j] + x[i]*x[j]*b[c-1])
+					}
+				}
+				for i := 0; i < k; i++ {
+					db[c][i] += r * (db[c-1][i] + x[i]*b[c-1])
+				}
+				b[c] += r * b[c-1]
+			}
+		}
+		denom, ddenom, d2denom := b[d.cases], db[d.cases], d2b[d.cases]
+		ll += dot(d.caseSum, beta) - math.Log(denom)
+		for i := 0; i < k; i++ {
+			gradient[i] += d.caseSum[i] - ddenom[i]/denom
+			for j := 0; j < k; j++ {
+				information[i][j] += d2denom[i][j]/denom - ddenom[i]*ddenom[j]/(denom*denom)
+			}
+		}
+	}
+	return ll, gradient, information
+}
+
+// LikelihoodRatio returns the likelihood ratio test of the model against the
+// one with all coefficients 0.
+func (m *ConditionalLogisticModel) LikelihoodRatio() (chi2 float64, df int, p float64) {
+	chi2 = 2 * (m.LogLikelihood - m.NullLogLikelihood)
+	df = len(m.Coefficients)
+	return chi2, df, chiSquarePValue(chi2, df)
+}
+
+// WriteConditionalLogisticModel writes the coefficients with their odds
+// ratios and the likelihood ratio test.
+func WriteConditionalLogisticModel(w *csv.Writer, m *ConditionalLogisticModel) error {
+	header := []string{"Term", "Koeffizient", "SE", "z", "p", "Odds Ratio", "OR 95% KI unten", "OR 95% KI oben"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for i, c := range m.Covariates {
+		coef, se := m.Coefficients[i], m.StdErrors[i]
+		row := []string{
+			c.Name,
+			float64Str(coef),
+			float64Str(se),
+			float64Str(coef / se),
+			float64Str(normalPValue(coef / se)),
+			float64Str(math.Exp(coef)),
+			float64Str(math.Exp(coef - z95*se)),
+			float64Str(math.Exp(coef + z95*se)),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	chi2, df, p := m.LikelihoodRatio()
+	footer := [][]string{
+		{"n", fmt.Sprintf("%d", m.N), "Sets", fmt.Sprintf("%d", m.Sets)},
+		{"Log-Likelihood", float64Str(m.LogLikelihood), "Log-Likelihood (Nullmodell)", float64Str(m.NullLogLikelihood)},
+		{"LR Chi-Quadrat", float64Str(chi2), "Freiheitsgrade", fmt.Sprintf("%d", df), "p", float64Str(p)},
+	}
+	for _, row := range footer {
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
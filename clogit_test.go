@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_FitConditionalLogistic(t *testing.T) {
+	// In 1:1 pairs with a single binary covariate the estimate is the log
+	// of the discordant pairs with an exposed case over those with an
+	// exposed control, as for McNemar's odds ratio.
+	exposure := Covariate{"Exposure", func(s *Subject) (float64, bool) {
+		return s.IgGTiter, s.IgGTiter >= 0
+	}}
+	var sets MatchedSets
+	pairs := []struct {
+		Control, Case float64
+		Count         int
+	}{
+		{1, 0, 4},
+		{0, 1, 10},
+		{1, 1, 6},
+		{0, 0, 5},
+		{-1, 1, 3},
+	}
+	for _, p := range pairs {
+		for i := 0; i < p.Count; i++ {
+			sets = append(sets, MatchedSet{
+				Controls: []*Subject{{IgGTiter: p.Control}},
+				Cases:    []*Subject{{IgGTiter: p.Case}},
+			})
+		}
+	}
+	m, err := FitConditionalLogistic(sets, []Covariate{exposure})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := math.Log(10.0 / 4); math.Abs(m.Coefficients[0]-want) > 1e-6 {
+		t.Errorf("want coefficient %f, got %f", want, m.Coefficients[0])
+	}
+	if want := math.Sqrt(1.0/10 + 1.0/4); math.Abs(m.StdErrors[0]-want) > 1e-6 {
+		t.Errorf("want std error %f, got %f", want, m.StdErrors[0])
+	}
+	if m.N != 50 || m.Sets != 25 {
+		t.Errorf("want 50 subjects in 25 sets, got %d in %d", m.N, m.Sets)
+	}
+	wantNull := -25 * math.Ln2
+	wantLL := -11*math.Ln2 + 10*math.Log(10.0/14) + 4*math.Log(4.0/14)
+	if math.Abs(m.NullLogLikelihood-wantNull) > 1e-6 || math.Abs(m.LogLikelihood-wantLL) > 1e-6 {
+		t.Errorf("want log likelihoods %f and %f, got %f and %f", wantNull, wantLL, m.NullLogLikelihood, m.LogLikelihood)
+	}
+	chi2, df, p := m.LikelihoodRatio()
+	if want := 2 * (wantLL - wantNull); math.Abs(chi2-want) > 1e-6 || df != 1 || math.Abs(p-chiSquarePValue(want, 1)) > 1e-9 {
+		t.Errorf("got LR test %f, %d, %f", chi2, df, p)
+	}
+	if _, err := FitConditionalLogistic(sets[:4], []Covariate{exposure}); err == nil {
+		t.Errorf("want error for separated case status")
+	}
+}
+
+func Test_clogitLikelihood(t *testing.T) {
+	// A set with two cases and three controls, compared to summing over all
+	// ten ways to pick two of the five subjects.
+	d := clogitSet{
+		x:     [][]float64{{1, 0.5}, {0, 2}, {1, -1}, {0, 0}, {-2, 1}},
+		cases: 2,
+	}
+	d.caseSum = []float64{d.x[0][0] + d.x[1][0], d.x[0][1] + d.x[1][1]}
+	beta := []float64{0.3, -0.7}
+	bruteForce := func(beta []float64) float64 {
+		var denom float64
+		for i := range d.x {
+			for j := i + 1; j < len(d.x); j++ {
+				denom += math.Exp(dot(d.x[i], beta) + dot(d.x[j], beta))
+			}
+		}
+		return dot(d.caseSum, beta) - math.Log(denom)
+	}
+	ll, gradient, information := clogitLikelihood([]clogitSet{d}, beta)
+	if want := bruteForce(beta); math.Abs(ll-want) > 1e-9 {
+		t.Errorf("want log likelihood %f, got %f", want, ll)
+	}
+	const h = 1e-5
+	for i := range beta {
+		up := append([]float64{}, beta...)
+		down := append([]float64{}, beta...)
+		up[i] += h
+		down[i] -= h
+		if want := (bruteForce(up) - bruteForce(down)) / (2 * h); math.Abs(gradient[i]-want) > 1e-6 {
+			t.Errorf("gradient %d: want %f, got %f", i, want, gradient[i])
+		}
+		_, gradientUp, _ := clogitLikelihood([]clogitSet{d}, up)
+		_, gradientDown, _ := clogitLikelihood([]clogitSet{d}, down)
+		for j := range beta {
+			if want := -(gradientUp[j] - gradientDown[j]) / (2 * h); math.Abs(information[i][j]-want) > 1e-5 {
+				t.Errorf("information %d,%d: want %f, got %f", i, j, want, information[i][j])
+			}
+		}
+	}
+}
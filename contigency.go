@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/csv"
 	"fmt"
+	"math"
+	"strconv"
 )
 
 type ContingencySubject interface {
@@ -10,27 +12,85 @@ type ContingencySubject interface {
 	Left() string
 }
 
-func WriteContingency(w *csv.Writer, top, left []string, subjects []ContingencySubject) error {
-	topRow := []string{"Title"}
-	for _, v := range top {
-		topRow = append(topRow, v)
+// WeightedContingencySubject is counted with its Weight instead of 1.
+type WeightedContingencySubject interface {
+	ContingencySubject
+	Weight() float64
+}
+
+type weightedContingencySubject struct {
+	ContingencySubject
+	weight float64
+}
+
+func (s weightedContingencySubject) Weight() float64 {
+	return s.weight
+}
+
+// weighted returns cs counted with the weight of s, or cs itself if weights
+// is nil.
+func (weights Weights) weighted(s *Subject, cs ContingencySubject) ContingencySubject {
+	if weights == nil {
+		return cs
 	}
-	if err := w.Write(topRow); err != nil {
-		return err
+	return weightedContingencySubject{cs, weights.Of(s)}
+}
+
+// Contingency is a table of counts with one row per left and one column per
+// top value. Title is the top-left cell, which Prism imports rely on.
+// Weighted is set if any subject was counted with a weight other than 1.
+type Contingency struct {
+	Title    string
+	Top      []string
+	Left     []string
+	Counts   [][]float64
+	Weighted bool
+}
+
+// NewContingency counts subjects per cell. Subjects that implement
+// WeightedContingencySubject add their weight instead of 1. Subjects with
+// values that are not in top or left are left out. The Title is "Title".
+func NewContingency(top, left []string, subjects []ContingencySubject) Contingency {
+	c := Contingency{Title: "Title", Top: top, Left: left, Counts: make([][]float64, len(left))}
+	topIndex, leftIndex := map[string]int{}, map[string]int{}
+	for j, t := range top {
+		topIndex[t] = j
+	}
+	for i, l := range left {
+		leftIndex[l] = i
+		c.Counts[i] = make([]float64, len(top))
 	}
-	r := map[string]map[string]int{}
 	for _, s := range subjects {
-		tops := r[s.Left()]
-		if tops == nil {
-			r[s.Left()] = map[string]int{}
-			tops = r[s.Left()]
+		i, ok1 := leftIndex[s.Left()]
+		j, ok2 := topIndex[s.Top()]
+		if !ok1 || !ok2 {
+			continue
 		}
-		tops[s.Top()]++
+		weight := 1.0
+		if ws, ok := s.(WeightedContingencySubject); ok {
+			weight = ws.Weight()
+		}
+		if weight != 1 {
+			c.Weighted = true
+		}
+		c.Counts[i][j] += weight
+	}
+	return c
+}
+
+// Write writes the number of subjects per cell.
+func (c Contingency) Write(w *csv.Writer) error {
+	topRow := []string{c.Title}
+	for _, v := range c.Top {
+		topRow = append(topRow, v)
 	}
-	for _, l := range left {
+	if err := w.Write(topRow); err != nil {
+		return err
+	}
+	for i, l := range c.Left {
 		row := []string{l}
-		for _, t := range top {
-			val := fmt.Sprintf("%d", r[l][t])
+		for j := range c.Top {
+			val := strconv.FormatFloat(c.Counts[i][j], 'f', -1, 64)
 			row = append(row, val)
 		}
 		if err := w.Write(row); err != nil {
@@ -40,10 +100,16 @@ func WriteContingency(w *csv.Writer, top, left []string, subjects []ContingencyS
 	return nil
 }
 
-func IgG_MS_GKSubjects(subject []*Subject) []ContingencySubject {
+// WriteContingency writes the number of subjects per cell, see
+// NewContingency.
+func WriteContingency(w *csv.Writer, top, left []string, subjects []ContingencySubject) error {
+	return NewContingency(top, left, subjects).Write(w)
+}
+
+func IgG_MS_GKSubjects(subject []*Subject, weights Weights) []ContingencySubject {
 	r := []ContingencySubject{}
 	for _, s := range subject {
-		r = append(r, IgG_MS_GKSubject{s})
+		r = append(r, weights.weighted(s, IgG_MS_GKSubject{s}))
 	}
 	return r
 }
@@ -63,10 +129,10 @@ func (s IgG_MS_GKSubject) Left() string {
 	return s.IgG.String()
 }
 
-func IgM_MS_GKSubjects(subject []*Subject) []ContingencySubject {
+func IgM_MS_GKSubjects(subject []*Subject, weights Weights) []ContingencySubject {
 	r := []ContingencySubject{}
 	for _, s := range subject {
-		r = append(r, IgM_MS_GKSubject{s})
+		r = append(r, weights.weighted(s, IgM_MS_GKSubject{s}))
 	}
 	return r
 }
@@ -86,13 +152,13 @@ func (s IgM_MS_GKSubject) Left() string {
 	return s.IgM.String()
 }
 
-func ANA_Nikotinabusus_MS_Subjects(subject []*Subject) []ContingencySubject {
+func ANA_Nikotinabusus_MS_Subjects(subject []*Subject, weights Weights) []ContingencySubject {
 	r := []ContingencySubject{}
 	for _, s := range subject {
 		if s.Diagnosis == GK {
 			continue
 		}
-		r = append(r, ANA_Nikotinabusus_Subject{s})
+		r = append(r, weights.weighted(s, ANA_Nikotinabusus_Subject{s}))
 	}
 	return r
 }
@@ -108,3 +174,361 @@ func (s ANA_Nikotinabusus_Subject) Top() string {
 func (s ANA_Nikotinabusus_Subject) Left() string {
 	return s.ANA.String()
 }
+
+func IgG_TreatmentSubjects(subject []*Subject) []ContingencySubject {
+	r := []ContingencySubject{}
+	for _, s := range subject {
+		if s.TherapyGroup() == TherapyNA {
+			continue
+		}
+		r = append(r, IgG_TreatmentSubject{s})
+	}
+	return r
+}
+
+type IgG_TreatmentSubject struct {
+	*Subject
+}
+
+func (s IgG_TreatmentSubject) Top() string {
+	if s.IgG {
+		return "Positiv"
+	}
+	return "Negativ"
+}
+
+func (s IgG_TreatmentSubject) Left() string {
+	return string(s.TherapyGroup())
+}
+
+func Nikotinabusus_MS_GKSubjects(subject []*Subject) []ContingencySubject {
+	r := []ContingencySubject{}
+	for _, s := range subject {
+		r = append(r, Nikotinabusus_MS_GKSubject{s})
+	}
+	return r
+}
+
+type Nikotinabusus_MS_GKSubject struct {
+	*Subject
+}
+
+func (s Nikotinabusus_MS_GKSubject) Top() string {
+	if s.Diagnosis != GK {
+		return "MS"
+	}
+	return "GK"
+}
+
+func (s Nikotinabusus_MS_GKSubject) Left() string {
+	return string(s.Nikotinabusus)
+}
+
+// ContingencyStats are tests of independence of the rows and columns of a
+// Contingency. Rows and columns without any subjects are left out.
+type ContingencyStats struct {
+	N          float64
+	Rows, Cols int
+	ChiSquare  float64
+	DF         int
+	P          float64
+	// FisherP is the two-sided p-value of Fisher's exact test, or of the
+	// Fisher-Freeman-Halton test for tables larger than 2x2. It is NaN if
+	// the counts are not whole numbers, e.g. weighted, or if the table has
+	// too many arrangements to enumerate.
+	FisherP  float64
+	CramersV float64
+}
+
+// maxFisherTables limits the number of tables Fisher-Freeman-Halton
+// enumerates.
+const maxFisherTables = 10000000
+
+// Stats returns the tests of c.
+func (c Contingency) Stats() ContingencyStats {
+	counts := c.nonEmpty()
+	stats := ContingencyStats{Rows: len(counts), ChiSquare: math.NaN(), P: math.NaN(), FisherP: math.NaN(), CramersV: math.NaN()}
+	if stats.Rows > 0 {
+		stats.Cols = len(counts[0])
+	}
+	rowSums := make([]float64, stats.Rows)
+	colSums := make([]float64, stats.Cols)
+	for i, row := range counts {
+		for j, v := range row {
+			rowSums[i] += v
+			colSums[j] += v
+			stats.N += v
+		}
+	}
+	if stats.Rows < 2 || stats.Cols < 2 {
+		return stats
+	}
+	stats.ChiSquare = 0
+	for i, row := range counts {
+		for j, v := range row {
+			expected := rowSums[i] * colSums[j] / stats.N
+			stats.ChiSquare += (v - expected) * (v - expected) / expected
+		}
+	}
+	stats.DF = (stats.Rows - 1) * (stats.Cols - 1)
+	stats.P = chiSquarePValue(stats.ChiSquare, stats.DF)
+	k := stats.Rows
+	if stats.Cols < k {
+		k = stats.Cols
+	}
+	stats.CramersV = math.Sqrt(stats.ChiSquare / (stats.N * float64(k-1)))
+	if ints, ok := wholeCounts(counts); ok {
+		stats.FisherP = fisherExactP(ints)
+	}
+	return stats
+}
+
+// naLevel is the level of subjects with an unknown value.
+const naLevel = "n/a"
+
+// Known returns c without the n/a rows and columns, and the number of
+// subjects in them. Unknown is not a category of its own, so the tests
+// leave it out.
+func (c Contingency) Known() (Contingency, float64) {
+	known := Contingency{Title: c.Title, Weighted: c.Weighted}
+	var cols []int
+	for j, t := range c.Top {
+		if t != naLevel {
+			known.Top = append(known.Top, t)
+			cols = append(cols, j)
+		}
+	}
+	var left float64
+	for i, l := range c.Left {
+		var row []float64
+		for j, v := range c.Counts[i] {
+			if l == naLevel || c.Top[j] == naLevel {
+				left += v
+			}
+		}
+		if l == naLevel {
+			continue
+		}
+		for _, j := range cols {
+			row = append(row, c.Counts[i][j])
+		}
+		known.Left = append(known.Left, l)
+		known.Counts = append(known.Counts, row)
+	}
+	return known, left
+}
+
+// nonEmpty returns the counts without empty rows and columns.
+func (c Contingency) nonEmpty() [][]float64 {
+	var cols []int
+	for j := range c.Top {
+		for i := range c.Left {
+			if c.Counts[i][j] != 0 {
+				cols = append(cols, j)
+				break
+			}
+		}
+	}
+	var counts [][]float64
+	for i := range c.Left {
+		var row []float64
+		empty := true
+		for _, j := range cols {
+			row = append(row, c.Counts[i][j])
+			if c.Counts[i][j] != 0 {
+				empty = false
+			}
+		}
+		if !empty {
+			counts = append(counts, row)
+		}
+	}
+	return counts
+}
+
+func wholeCounts(counts [][]float64) ([][]int, bool) {
+	ints := make([][]int, len(counts))
+	for i, row := range counts {
+		for _, v := range row {
+			if v != math.Trunc(v) || v < 0 {
+				return nil, false
+			}
+			ints[i] = append(ints[i], int(v))
+		}
+	}
+	return ints, true
+}
+
+// fisherExactP returns the two-sided p-value of the Fisher-Freeman-Halton
+// test, the sum of the probabilities of all tables with the margins of
+// counts that are not more likely than counts. For 2x2 tables this is
+// Fisher's exact test.
+func fisherExactP(counts [][]int) float64 {
+	rows, cols := len(counts), len(counts[0])
+	rowSums := make([]int, rows)
+	colSums := make([]int, cols)
+	n := 0
+	for i, row := range counts {
+		for j, v := range row {
+			rowSums[i] += v
+			colSums[j] += v
+			n += v
+		}
+	}
+	// logP is the log probability of a table without the terms that are
+	// the same for all tables with these margins.
+	logP := func(table [][]int) float64 {
+		var p float64
+		for _, row := range table {
+			for _, v := range row {
+				lg, _ := math.Lgamma(float64(v + 1))
+				p -= lg
+			}
+		}
+		return p
+	}
+	var constant float64
+	for _, v := range append(append([]int{}, rowSums...), colSums...) {
+		lg, _ := math.Lgamma(float64(v + 1))
+		constant += lg
+	}
+	lgN, _ := math.Lgamma(float64(n + 1))
+	constant -= lgN
+	observed := logP(counts)
+	// Tables that are as likely as counts up to rounding count as well.
+	limit := observed + 1e-7
+	var (
+		p       float64
+		tables  int
+		table   = make([][]int, rows)
+		rowLeft = append([]int{}, rowSums...)
+	)
+	for i := range table {
+		table[i] = make([]int, cols)
+	}
+	// fill assigns column j from row i on, the last row and column are
+	// implied by the margins.
+	var fill func(i, j, colLeft int) bool
+	fill = func(i, j, colLeft int) bool {
+		if j == cols-1 {
+			for r := range table {
+				table[r][j] = rowLeft[r]
+			}
+			if tables++; tables > maxFisherTables {
+				return false
+			}
+			if lp := logP(table); lp <= limit {
+				p += math.Exp(constant + lp)
+			}
+			return true
+		}
+		if i == rows-1 {
+			table[i][j] = colLeft
+			if colLeft > rowLeft[i] {
+				return true
+			}
+			rowLeft[i] -= colLeft
+			ok := fill(0, j+1, colSums[j+1])
+			rowLeft[i] += colLeft
+			return ok
+		}
+		// The remaining rows must be able to take the rest of the column.
+		rest := 0
+		for r := i + 1; r < rows; r++ {
+			rest += rowLeft[r]
+		}
+		lo := colLeft - rest
+		if lo < 0 {
+			lo = 0
+		}
+		hi := colLeft
+		if rowLeft[i] < hi {
+			hi = rowLeft[i]
+		}
+		for v := lo; v <= hi; v++ {
+			table[i][j] = v
+			rowLeft[i] -= v
+			ok := fill(i+1, j, colLeft-v)
+			rowLeft[i] += v
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+	if !fill(0, 0, colSums[0]) {
+		return math.NaN()
+	}
+	return math.Min(1, p)
+}
+
+// WriteContingencyStats writes the tests of c, one per row. With an effect
+// it adds the odds and risk ratios of the 2x2 table it selects. The n/a rows
+// and columns are left out, only their number is written. The tests need
+// unweighted counts, so a Weighted table only gets its n and the reason.
+func WriteContingencyStats(w *csv.Writer, c Contingency, effect *Effect) error {
+	c, unknown := c.Known()
+	if c.Weighted {
+		var n float64
+		for _, row := range c.Counts {
+			for _, v := range row {
+				n += v
+			}
+		}
+		rows := [][]string{
+			{"Statistik", "Wert"},
+			{"n (gewichtet)", strconv.FormatFloat(n, 'f', -1, 64)},
+			{"n/a ausgeschlossen", strconv.FormatFloat(unknown, 'f', -1, 64)},
+			{"Tests", "ausgelassen, gewichtete Häufigkeiten (Zurücklegen, mehrere Kontrollen oder CEM) passen nicht zu Chi-Quadrat und Fisher"},
+		}
+		return w.WriteAll(rows)
+	}
+	stats := c.Stats()
+	fisherName := "p (Fisher-Freeman-Halton)"
+	if stats.Rows == 2 && stats.Cols == 2 {
+		fisherName = "p (Fisher exakt)"
+	}
+	rows := [][]string{
+		{"Statistik", "Wert"},
+		{"n", strconv.FormatFloat(stats.N, 'f', -1, 64)},
+		{"n/a ausgeschlossen", strconv.FormatFloat(unknown, 'f', -1, 64)},
+		{"Zeilen", fmt.Sprintf("%d", stats.Rows)},
+		{"Spalten", fmt.Sprintf("%d", stats.Cols)},
+		{"Chi-Quadrat", strconv.FormatFloat(stats.ChiSquare, 'f', -1, 64)},
+		{"Freiheitsgrade", fmt.Sprintf("%d", stats.DF)},
+		{"p (Chi-Quadrat)", strconv.FormatFloat(stats.P, 'f', -1, 64)},
+		{fisherName, strconv.FormatFloat(stats.FisherP, 'f', -1, 64)},
+		{"Cramérs V", strconv.FormatFloat(stats.CramersV, 'f', -1, 64)},
+	}
+	if effect != nil {
+		t, err := c.TwoByTwo(*effect)
+		if err != nil {
+			return err
+		}
+		exactLower, exactUpper := t.ExactOddsRatioCI()
+		corrected, haldane := t.Haldane()
+		or, orLower, orUpper := corrected.OddsRatio()
+		rr, rrLower, rrUpper := corrected.RiskRatio()
+		for _, stat := range []struct {
+			Name  string
+			Value float64
+		}{
+			{"Odds Ratio", or},
+			{"Odds Ratio 95% KI unten (Woolf)", orLower},
+			{"Odds Ratio 95% KI oben (Woolf)", orUpper},
+			{"Odds Ratio 95% KI unten (exakt, Cornfield)", exactLower},
+			{"Odds Ratio 95% KI oben (exakt, Cornfield)", exactUpper},
+			{"Relatives Risiko", rr},
+			{"Relatives Risiko 95% KI unten (Wald)", rrLower},
+			{"Relatives Risiko 95% KI oben (Wald)", rrUpper},
+		} {
+			rows = append(rows, []string{stat.Name, strconv.FormatFloat(stat.Value, 'f', -1, 64)})
+		}
+		rows = append(rows,
+			[]string{"Exponiert (Spalte)", effect.Exposed},
+			[]string{"Ergebnis (Zeile)", effect.Outcome},
+			[]string{"Haldane-Korrektur", yesNo(haldane)},
+		)
+	}
+	return w.WriteAll(rows)
+}
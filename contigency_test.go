@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Contingency_Stats(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		Counts    [][]float64
+		N         float64
+		ChiSquare float64
+		DF        int
+		P         float64
+		FisherP   float64
+		CramersV  float64
+	}{
+		{
+			Counts:    [][]float64{{3, 1}, {1, 3}},
+			N:         8,
+			ChiSquare: 2,
+			DF:        1,
+			P:         0.157299,
+			FisherP:   0.485714,
+			CramersV:  0.5,
+		},
+		{
+			Counts:    [][]float64{{3, 1, 0}, {0, 0, 0}, {1, 3, 0}},
+			N:         8,
+			ChiSquare: 2,
+			DF:        1,
+			P:         0.157299,
+			FisherP:   0.485714,
+			CramersV:  0.5,
+		},
+		{
+			Counts:    [][]float64{{1, 2, 3}, {3, 2, 1}},
+			N:         12,
+			ChiSquare: 2,
+			DF:        2,
+			P:         0.367879,
+			FisherP:   0.766234,
+			CramersV:  0.408248,
+		},
+		{
+			Counts:    [][]float64{{2, 0, 1}, {0, 3, 1}, {1, 1, 2}},
+			N:         11,
+			ChiSquare: 6.034722,
+			DF:        4,
+			P:         0.196570,
+			FisherP:   0.401558,
+			CramersV:  0.523742,
+		},
+		{
+			Counts:    [][]float64{{1.5, 1}, {1, 3}},
+			N:         6.5,
+			ChiSquare: 0.79625,
+			DF:        1,
+			P:         0.372217,
+			FisherP:   nan,
+			CramersV:  0.35,
+		},
+		{
+			Counts:    [][]float64{{3, 1}, {0, 0}},
+			N:         4,
+			ChiSquare: nan,
+			P:         nan,
+			FisherP:   nan,
+			CramersV:  nan,
+		},
+	}
+	near := func(a, b float64) bool {
+		if math.IsNaN(b) {
+			return math.IsNaN(a)
+		}
+		return math.Abs(a-b) < 1e-5
+	}
+	for i, test := range tests {
+		c := Contingency{Counts: test.Counts}
+		for range test.Counts {
+			c.Left = append(c.Left, "")
+		}
+		for range test.Counts[0] {
+			c.Top = append(c.Top, "")
+		}
+		got := c.Stats()
+		if got.N != test.N || got.DF != test.DF || !near(got.ChiSquare, test.ChiSquare) || !near(got.P, test.P) || !near(got.FisherP, test.FisherP) || !near(got.CramersV, test.CramersV) {
+			t.Errorf("test %d: got %+v", i, got)
+		}
+	}
+}
+
+func Test_Contingency_Known(t *testing.T) {
+	c := Contingency{
+		Title:  "Nikotinabusus",
+		Top:    []string{"GK", "MS"},
+		Left:   []string{"ja", "nein", "n/a"},
+		Counts: [][]float64{{3, 1}, {1, 3}, {2, 5}},
+	}
+	known, unknown := c.Known()
+	want := Contingency{Title: "Nikotinabusus", Top: []string{"GK", "MS"}, Left: []string{"ja", "nein"}, Counts: [][]float64{{3, 1}, {1, 3}}}
+	if !reflect.DeepEqual(known, want) || unknown != 7 {
+		t.Errorf("got %+v and %f unknown", known, unknown)
+	}
+	c = Contingency{Top: []string{"positiv", "n/a"}, Left: []string{"a", "b"}, Counts: [][]float64{{1, 2}, {3, 4}}}
+	if known, unknown := c.Known(); !reflect.DeepEqual(known.Top, []string{"positiv"}) || !reflect.DeepEqual(known.Counts, [][]float64{{1}, {3}}) || unknown != 6 {
+		t.Errorf("got %+v and %f unknown", known, unknown)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := WriteContingencyStats(w, Contingency{Top: []string{"GK", "MS"}, Left: []string{"ja", "nein", "n/a"}, Counts: [][]float64{{3, 1}, {1, 3}, {2, 5}}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	for _, line := range []string{"n,8\n", "n/a ausgeschlossen,7\n", "Zeilen,2\n", "Chi-Quadrat,2\n"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("want %q in %s", line, buf.String())
+		}
+	}
+}
+
+func Test_chiSquarePValue(t *testing.T) {
+	tests := []struct {
+		X    float64
+		DF   int
+		Want float64
+	}{
+		{3.841459, 1, 0.05},
+		{10, 1, 0.001565},
+		{9.487729, 4, 0.05},
+		{0.5, 3, 0.918891},
+		{0, 2, 1},
+	}
+	for i, test := range tests {
+		if got := chiSquarePValue(test.X, test.DF); math.Abs(got-test.Want) > 1e-6 {
+			t.Errorf("test %d: got %f, want %f", i, got, test.Want)
+		}
+	}
+}
+
+func Test_WriteContingencyStats_weighted(t *testing.T) {
+	ms, gk, control := &Subject{Diagnosis: RRMS, IgG: true}, &Subject{Diagnosis: GK, IgG: false}, &Subject{Diagnosis: GK, IgG: true}
+	subjects := []*Subject{ms, gk, control}
+	tests := []struct {
+		Weights  Weights
+		Weighted bool
+	}{
+		{Weights: nil},
+		{Weights: Weights{ms: 1, gk: 1, control: 1}},
+		{Weights: Weights{ms: 1, gk: 0.5, control: 0.5}, Weighted: true},
+	}
+	for i, test := range tests {
+		c := NewContingency([]string{"MS", "GK"}, []string{"positiv", "negativ"}, IgG_MS_GKSubjects(subjects, test.Weights))
+		if c.Weighted != test.Weighted {
+			t.Errorf("test %d: want Weighted %t, got %t", i, test.Weighted, c.Weighted)
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := WriteContingencyStats(w, c, &Effect{"MS", "positiv"}); err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		w.Flush()
+		if got := strings.Contains(buf.String(), "Chi-Quadrat,"); got == test.Weighted {
+			t.Errorf("test %d: want tests %t, got %s", i, !test.Weighted, buf.String())
+		}
+		if got := strings.Contains(buf.String(), "n (gewichtet),2\n"); got != test.Weighted {
+			t.Errorf("test %d: want weighted n %t, got %s", i, test.Weighted, buf.String())
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func CovariateByName(name string) (Covariate, error) {
+	for _, c := range Covariates {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Covariate{}, fmt.Errorf("Unknown covariate: %s", name)
+}
+
+// Covariate is a numeric property of a subject. Binary properties are coded
+// as 1 and 0. Value returns false if the subject has no value for it.
+type Covariate struct {
+	Name  string
+	Value func(s *Subject) (float64, bool)
+}
+
+var Covariates = []Covariate{
+	{"Age", func(s *Subject) (float64, bool) {
+		return s.Age, true
+	}},
+	{"Gender", func(s *Subject) (float64, bool) {
+		return binary(s.Gender == Female), true
+	}},
+	{"Nikotinabusus", func(s *Subject) (float64, bool) {
+		if s.Nikotinabusus == NA {
+			return 0, false
+		}
+		return binary(s.Nikotinabusus == Yes), true
+	}},
+	{"IgG", func(s *Subject) (float64, bool) {
+		return binary(bool(s.IgG)), true
+	}},
+	{"IgM", func(s *Subject) (float64, bool) {
+		return naStatusValue(s.IgM)
+	}},
+	{"IgGTiter", func(s *Subject) (float64, bool) {
+		return s.IgGTiter, true
+	}},
+	{"IgGTotal", func(s *Subject) (float64, bool) {
+		return float64PtrValue(s.IgGTotal)
+	}},
+	{"QIgG", func(s *Subject) (float64, bool) {
+		return float64PtrValue(s.QIgG)
+	}},
+	{"ANA", func(s *Subject) (float64, bool) {
+		return naStatusValue(s.ANA)
+	}},
+	{"AgeEM", func(s *Subject) (float64, bool) {
+		return float64PtrValue(s.AgeEM)
+	}},
+	{"SickDuration", func(s *Subject) (float64, bool) {
+		if s.SickDuration == nil || *s.SickDuration < 0 {
+			return 0, false
+		}
+		return *s.SickDuration, true
+	}},
+	{"EDSS", func(s *Subject) (float64, bool) {
+		return float64PtrValue(s.EDSS)
+	}},
+	{"NumRelapse", func(s *Subject) (float64, bool) {
+		return float64PtrValue(s.NumRelapse)
+	}},
+}
+
+// ParseCovariates parses a comma separated list of covariate names.
+func ParseCovariates(val string) ([]Covariate, error) {
+	var covariates []Covariate
+	for _, name := range strings.Split(val, ",") {
+		c, err := CovariateByName(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		covariates = append(covariates, c)
+	}
+	return covariates, nil
+}
+
+func binary(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func naStatusValue(s NAStatus) (float64, bool) {
+	if s == NASNA {
+		return 0, false
+	}
+	return binary(s == NASPositiv), true
+}
+
+func float64PtrValue(v *float64) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// excelEpoch is day 0 of Excel's 1900 date system. It is the 30th, not the
+// 31st of December, because Excel treats 1900 as a leap year.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// ParseDate parses a German date like 02.01.2006 or 2.1.2006, an ISO date
+// like 2006-01-02, or an Excel serial number like 38719. A time of day after
+// the date is ignored.
+func ParseDate(val string) (time.Time, error) {
+	val = strings.TrimSpace(val)
+	if i := strings.IndexByte(val, ' '); i > 0 {
+		val = val[:i]
+	}
+	for _, layout := range []string{"2.1.2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t, nil
+		}
+	}
+	serial, err := strconv.ParseFloat(strings.Replace(val, ",", ".", -1), 64)
+	if err == nil && serial >= 1 && serial < 2958466 {
+		return excelEpoch.AddDate(0, 0, int(math.Floor(serial))), nil
+	}
+	return time.Time{}, fmt.Errorf("Bad date: %s", val)
+}
+
+func dateStr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("02.01.2006")
+}
+
+// AgeAt returns the age in years on ref of someone born on birth, to the
+// day: the completed years plus the share of the current year of life that
+// has passed.
+func AgeAt(birth, ref time.Time) float64 {
+	years := ref.Year() - birth.Year()
+	last := birth.AddDate(years, 0, 0)
+	if last.After(ref) {
+		years--
+		last = birth.AddDate(years, 0, 0)
+	}
+	next := birth.AddDate(years+1, 0, 0)
+	return float64(years) + ref.Sub(last).Hours()/next.Sub(last).Hours()
+}
+
+// ComputedAge returns the age at sampling derived from the birth and
+// sampling dates, if both are known.
+func (s *Subject) ComputedAge() (float64, bool) {
+	if s.Birthday == nil || s.SamplingDate == nil {
+		return 0, false
+	}
+	return AgeAt(*s.Birthday, *s.SamplingDate), true
+}
+
+// ComputedAgeEM returns the age at onset derived from the birth and onset
+// dates, if both are known.
+func (s *Subject) ComputedAgeEM() (float64, bool) {
+	if s.Birthday == nil || s.OnsetDate == nil {
+		return 0, false
+	}
+	return AgeAt(*s.Birthday, *s.OnsetDate), true
+}
+
+// AgeSource selects whether matching uses the ages from the sheet or the
+// ones computed from dates.
+type AgeSource string
+
+const (
+	SheetAge    AgeSource = "sheet"
+	ComputedAge AgeSource = "computed"
+)
+
+func ParseAgeSource(val string) (AgeSource, error) {
+	for _, a := range []AgeSource{SheetAge, ComputedAge} {
+		if string(a) == val {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("Bad AgeSource: %s", val)
+}
+
+// UseComputedAges replaces Age and AgeEM with the values computed from dates
+// where those are known. It returns the number of subjects whose Age could
+// not be computed and was kept from the sheet.
+func UseComputedAges(subjects Subjects) int {
+	kept := 0
+	for _, s := range subjects {
+		if age, ok := s.ComputedAge(); ok {
+			s.Age = age
+		} else {
+			kept++
+		}
+		if ageEM, ok := s.ComputedAgeEM(); ok {
+			s.AgeEM = &ageEM
+		}
+	}
+	return kept
+}
+
+// AgeMismatch is a subject whose age in the sheet differs from the age
+// computed from dates by a year or more.
+type AgeMismatch struct {
+	Subject  *Subject
+	Field    string
+	Sheet    float64
+	Computed float64
+}
+
+// AgeMismatches compares Age and AgeEM with the computed ages. Sheet ages
+// are usually completed years, so only differences of at least one year
+// count.
+func AgeMismatches(subjects Subjects) []AgeMismatch {
+	var mismatches []AgeMismatch
+	for _, s := range subjects {
+		if age, ok := s.ComputedAge(); ok && math.Abs(s.Age-age) >= 1 {
+			mismatches = append(mismatches, AgeMismatch{s, "Age", s.Age, age})
+		}
+		if ageEM, ok := s.ComputedAgeEM(); ok && s.AgeEM != nil && math.Abs(*s.AgeEM-ageEM) >= 1 {
+			mismatches = append(mismatches, AgeMismatch{s, "AgeEM", *s.AgeEM, ageEM})
+		}
+	}
+	return mismatches
+}
+
+// WriteAgeMismatches writes one row per mismatch.
+func WriteAgeMismatches(w *csv.Writer, mismatches []AgeMismatch, mapping Mapping) error {
+	header := []string{"Zeile", "Patient", "Spalte", "Tabelle", "Berechnet", "Differenz"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, m := range mismatches {
+		row := []string{
+			fmt.Sprintf("%d", m.Subject.Line),
+			m.Subject.String(),
+			mapping.ColumnName(m.Field),
+			fmt.Sprintf("%f", m.Sheet),
+			fmt.Sprintf("%f", m.Computed),
+			fmt.Sprintf("%f", m.Sheet-m.Computed),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
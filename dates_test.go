@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseDate(t *testing.T) {
+	tests := []struct {
+		Val  string
+		Want time.Time
+		Err  bool
+	}{
+		{Val: "02.01.1980", Want: time.Date(1980, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Val: "2.1.1980", Want: time.Date(1980, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Val: "01.01.1980 12:00:00", Want: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Val: "1980-01-02", Want: time.Date(1980, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Val: "29221", Want: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Val: "29221,5", Want: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Val: "31.02.1980", Err: true},
+		{Val: "gestern", Err: true},
+		{Val: "0", Err: true},
+	}
+	for i, test := range tests {
+		got, err := ParseDate(test.Val)
+		if test.Err {
+			if err == nil {
+				t.Errorf("test %d: %s: want error, got %s", i, test.Val, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(test.Want) {
+			t.Errorf("test %d: %s: want %s, got %s (%v)", i, test.Val, test.Want, got, err)
+		}
+	}
+}
+
+func Test_AgeAt(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		Birth time.Time
+		Ref   time.Time
+		Want  float64
+	}{
+		{date(1980, 1, 1), date(2010, 1, 1), 30},
+		{date(1980, 1, 1), date(2009, 12, 31), 29 + 364.0/365},
+		{date(1980, 3, 1), date(2010, 9, 1), 30 + 184.0/365},
+		{date(1979, 3, 1), date(1980, 3, 1), 1},
+		{date(1980, 3, 1), date(1980, 3, 1), 0},
+	}
+	for i, test := range tests {
+		if got := AgeAt(test.Birth, test.Ref); got != test.Want {
+			t.Errorf("test %d: want %f, got %f", i, test.Want, got)
+		}
+	}
+}
+
+func Test_AgeMismatches(t *testing.T) {
+	date := func(y int, m time.Month, d int) *time.Time {
+		t := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	ageEM := 20.0
+	subjects := Subjects{
+		{Age: 30, Birthday: date(1980, 1, 1), SamplingDate: date(2010, 6, 1)},
+		{Age: 32, Birthday: date(1980, 1, 1), SamplingDate: date(2010, 6, 1), AgeEM: &ageEM, OnsetDate: date(2005, 1, 1)},
+		{Age: 50, Birthday: date(1980, 1, 1)},
+	}
+	mismatches := AgeMismatches(subjects)
+	if len(mismatches) != 2 || mismatches[0].Subject != subjects[1] || mismatches[0].Field != "Age" || mismatches[1].Field != "AgeEM" {
+		t.Fatalf("got %v", mismatches)
+	}
+	if kept := UseComputedAges(subjects); kept != 1 {
+		t.Errorf("want 1 subject without dates, got %d", kept)
+	}
+	if subjects[1].Age >= 31 || *subjects[1].AgeEM != 25 || subjects[2].Age != 50 {
+		t.Errorf("got ages %f, %f, %f", subjects[1].Age, *subjects[1].AgeEM, subjects[2].Age)
+	}
+}
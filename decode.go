@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is a text encoding of an input file.
+type Encoding string
+
+const (
+	UTF8        Encoding = "UTF-8"
+	UTF16LE     Encoding = "UTF-16LE"
+	UTF16BE     Encoding = "UTF-16BE"
+	Windows1252 Encoding = "Windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// decodeText converts data to UTF-8. The encoding is taken from the byte
+// order mark if there is one. Without one, data is treated as UTF-16 if
+// every other byte is zero, as UTF-8 if it is valid UTF-8, and as
+// Windows-1252 otherwise, which also covers Latin-1. The byte order mark is
+// not part of the result.
+func decodeText(data []byte) (string, Encoding, error) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return decodeUTF8(data[len(bomUTF8):], len(bomUTF8))
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return decodeUTF16(data[len(bomUTF16LE):], UTF16LE, len(bomUTF16LE))
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return decodeUTF16(data[len(bomUTF16BE):], UTF16BE, len(bomUTF16BE))
+	}
+	if enc, ok := sniffUTF16(data); ok {
+		return decodeUTF16(data, enc, 0)
+	}
+	if utf8.Valid(data) {
+		return decodeUTF8(data, 0)
+	}
+	return decodeWindows1252(data)
+}
+
+func decodeUTF8(data []byte, offset int) (string, Encoding, error) {
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			return "", UTF8, fmt.Errorf("Bad %s: invalid byte 0x%02x at offset %d", UTF8, data[i], offset+i)
+		}
+		i += size
+	}
+	return string(data), UTF8, nil
+}
+
+// sniffUTF16 detects UTF-16 without a byte order mark by the zero high bytes
+// of ASCII characters, which make up most of our exports.
+func sniffUTF16(data []byte) (Encoding, bool) {
+	if len(data) < 2 || len(data)%2 != 0 {
+		return "", false
+	}
+	var evenZeros, oddZeros int
+	for i := 0; i < len(data); i += 2 {
+		if data[i] == 0 {
+			evenZeros++
+		}
+		if data[i+1] == 0 {
+			oddZeros++
+		}
+	}
+	units := len(data) / 2
+	switch {
+	case oddZeros*2 > units && evenZeros == 0:
+		return UTF16LE, true
+	case evenZeros*2 > units && oddZeros == 0:
+		return UTF16BE, true
+	}
+	return "", false
+}
+
+func decodeUTF16(data []byte, enc Encoding, offset int) (string, Encoding, error) {
+	if len(data)%2 != 0 {
+		return "", enc, fmt.Errorf("Bad %s: odd number of bytes", enc)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if enc == UTF16LE {
+			units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		} else {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+	}
+	for i := 0; i < len(units); i++ {
+		u := units[i]
+		switch {
+		case u >= 0xd800 && u < 0xdc00:
+			if i+1 >= len(units) || units[i+1] < 0xdc00 || units[i+1] >= 0xe000 {
+				return "", enc, fmt.Errorf("Bad %s: unpaired surrogate at offset %d", enc, offset+2*i)
+			}
+			i++
+		case u >= 0xdc00 && u < 0xe000:
+			return "", enc, fmt.Errorf("Bad %s: unpaired surrogate at offset %d", enc, offset+2*i)
+		}
+	}
+	return string(utf16.Decode(units)), enc, nil
+}
+
+// windows1252 maps the bytes 0x80 to 0x9f to their characters. The bytes
+// that are undefined in Windows-1252 are 0.
+var windows1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(data []byte) (string, Encoding, error) {
+	var buf bytes.Buffer
+	for i, b := range data {
+		switch {
+		case b == 0:
+			return "", Windows1252, fmt.Errorf("Bad %s: NUL byte at offset %d, the file is probably UTF-16 without a byte order mark", Windows1252, i)
+		case b < 0x80 || b >= 0xa0:
+			buf.WriteRune(rune(b))
+		case windows1252[b-0x80] == 0:
+			return "", Windows1252, fmt.Errorf("Bad %s: undefined byte 0x%02x at offset %d", Windows1252, b, i)
+		default:
+			buf.WriteRune(windows1252[b-0x80])
+		}
+	}
+	return buf.String(), Windows1252, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_decodeText(t *testing.T) {
+	tests := []struct {
+		Data     []byte
+		Want     string
+		Encoding Encoding
+		Err      string
+	}{
+		{
+			Data:     []byte("Alter\tGeschlecht"),
+			Want:     "Alter\tGeschlecht",
+			Encoding: UTF8,
+		},
+		{
+			Data:     []byte("\xef\xbb\xbfSchübe"),
+			Want:     "Schübe",
+			Encoding: UTF8,
+		},
+		{
+			Data:     []byte("\xff\xfeS\x00c\x00h\x00\xfc\x00b\x00e\x00"),
+			Want:     "Schübe",
+			Encoding: UTF16LE,
+		},
+		{
+			Data:     []byte("\xfe\xff\x00S\x00c\x00h\x00\xfc\x00b\x00e"),
+			Want:     "Schübe",
+			Encoding: UTF16BE,
+		},
+		{
+			Data:     []byte("A\x00l\x00t\x00e\x00r\x00"),
+			Want:     "Alter",
+			Encoding: UTF16LE,
+		},
+		{
+			Data:     []byte("\xff\xfe=\xd8\x00\xde"),
+			Want:     "\U0001f600",
+			Encoding: UTF16LE,
+		},
+		{
+			Data:     []byte("Sch\xfcbe \x80"),
+			Want:     "Schübe €",
+			Encoding: Windows1252,
+		},
+		{
+			Data: []byte("\xef\xbb\xbfSch\xfcbe"),
+			Err:  "invalid byte 0xfc at offset 6",
+		},
+		{
+			Data: []byte("\xff\xfeA\x00B"),
+			Err:  "odd number of bytes",
+		},
+		{
+			Data: []byte("\xff\xfe\x00\xdcA\x00"),
+			Err:  "unpaired surrogate at offset 2",
+		},
+		{
+			Data: []byte("Sch\x81be"),
+			Err:  "undefined byte 0x81 at offset 3",
+		},
+		{
+			Data: []byte("A\x00\xfc"),
+			Err:  "NUL byte at offset 1",
+		},
+	}
+	for i, test := range tests {
+		got, enc, err := decodeText(test.Data)
+		if test.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Err) {
+				t.Errorf("test %d: want error %q, got %v", i, test.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+			continue
+		}
+		if got != test.Want || enc != test.Encoding {
+			t.Errorf("test %d: want %q (%s), got %q (%s)", i, test.Want, test.Encoding, got, enc)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Effect orients a 2x2 Contingency for odds and risk ratios: Exposed is the
+// column of the exposed group and Outcome the row of the outcome.
+type Effect struct {
+	Exposed string
+	Outcome string
+}
+
+// ParseEffects parses a comma separated list of table=column:row entries,
+// e.g. "IgG-MS-GK-Matched=MS:positiv".
+func ParseEffects(val string) (map[string]Effect, error) {
+	effects := map[string]Effect{}
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		nameEffect := strings.SplitN(part, "=", 2)
+		if len(nameEffect) != 2 {
+			return nil, fmt.Errorf("Bad effect: %s", part)
+		}
+		levels := strings.SplitN(nameEffect[1], ":", 2)
+		if len(levels) != 2 || levels[0] == "" || levels[1] == "" {
+			return nil, fmt.Errorf("Bad effect: %s", part)
+		}
+		effects[strings.TrimSpace(nameEffect[0])] = Effect{Exposed: levels[0], Outcome: levels[1]}
+	}
+	return effects, nil
+}
+
+// TwoByTwo are the cells of a 2x2 table oriented by an Effect.
+type TwoByTwo struct {
+	// A are the exposed with the outcome, B the exposed without it.
+	A, B float64
+	// C are the unexposed with the outcome, D the unexposed without it.
+	C, D float64
+}
+
+// TwoByTwo returns the cells of c oriented by e. c must have two columns
+// and two rows, and e must name one of each.
+func (c Contingency) TwoByTwo(e Effect) (TwoByTwo, error) {
+	if len(c.Top) != 2 || len(c.Left) != 2 {
+		return TwoByTwo{}, fmt.Errorf("odds ratios need a 2x2 table, got %dx%d", len(c.Left), len(c.Top))
+	}
+	exposed, outcome := -1, -1
+	for j, t := range c.Top {
+		if t == e.Exposed {
+			exposed = j
+		}
+	}
+	for i, l := range c.Left {
+		if l == e.Outcome {
+			outcome = i
+		}
+	}
+	if exposed < 0 {
+		return TwoByTwo{}, fmt.Errorf("Bad exposed column: %s", e.Exposed)
+	}
+	if outcome < 0 {
+		return TwoByTwo{}, fmt.Errorf("Bad outcome row: %s", e.Outcome)
+	}
+	unexposed, noOutcome := 1-exposed, 1-outcome
+	return TwoByTwo{
+		A: c.Counts[outcome][exposed],
+		B: c.Counts[noOutcome][exposed],
+		C: c.Counts[outcome][unexposed],
+		D: c.Counts[noOutcome][unexposed],
+	}, nil
+}
+
+// Haldane returns t with 0.5 added to every cell if any cell is 0, and
+// whether it did so.
+func (t TwoByTwo) Haldane() (TwoByTwo, bool) {
+	if t.A != 0 && t.B != 0 && t.C != 0 && t.D != 0 {
+		return t, false
+	}
+	return TwoByTwo{t.A + 0.5, t.B + 0.5, t.C + 0.5, t.D + 0.5}, true
+}
+
+// OddsRatio returns the odds ratio with its 95% Woolf interval.
+func (t TwoByTwo) OddsRatio() (or, lower, upper float64) {
+	or = t.A * t.D / (t.B * t.C)
+	se := math.Sqrt(1/t.A + 1/t.B + 1/t.C + 1/t.D)
+	return or, math.Exp(math.Log(or) - z95*se), math.Exp(math.Log(or) + z95*se)
+}
+
+// RiskRatio returns the risk of the outcome of the exposed over that of the
+// unexposed, with its 95% Wald interval on the log scale.
+func (t TwoByTwo) RiskRatio() (rr, lower, upper float64) {
+	exposed, unexposed := t.A+t.B, t.C+t.D
+	rr = (t.A / exposed) / (t.C / unexposed)
+	se := math.Sqrt(1/t.A - 1/exposed + 1/t.C - 1/unexposed)
+	return rr, math.Exp(math.Log(rr) - z95*se), math.Exp(math.Log(rr) + z95*se)
+}
+
+// ExactOddsRatioCI returns the exact 95% interval of the odds ratio after
+// Cornfield, the odds ratios at which the observed A is in the 2.5% tail of
+// the noncentral hypergeometric distribution given the margins. The bounds
+// are 0 and +Inf if A is at the end of its range. Both are NaN if the
+// counts are not whole numbers.
+func (t TwoByTwo) ExactOddsRatioCI() (lower, upper float64) {
+	ints, ok := wholeCounts([][]float64{{t.A, t.B, t.C, t.D}})
+	if !ok {
+		return math.NaN(), math.NaN()
+	}
+	a, b, c, d := ints[0][0], ints[0][1], ints[0][2], ints[0][3]
+	exposed, unexposed, outcome := a+b, c+d, a+c
+	lo := outcome - unexposed
+	if lo < 0 {
+		lo = 0
+	}
+	hi := exposed
+	if outcome < hi {
+		hi = outcome
+	}
+	if lo == hi {
+		return math.NaN(), math.NaN()
+	}
+	// tails returns P(X <= a) and P(X >= a) at the log odds ratio.
+	tails := func(logOR float64) (below, above float64) {
+		logWeights := make([]float64, hi-lo+1)
+		max := math.Inf(-1)
+		for x := lo; x <= hi; x++ {
+			w := logBinomial(exposed, x) + logBinomial(unexposed, outcome-x) + float64(x)*logOR
+			logWeights[x-lo] = w
+			max = math.Max(max, w)
+		}
+		var total float64
+		for x := lo; x <= hi; x++ {
+			w := math.Exp(logWeights[x-lo] - max)
+			total += w
+			if x <= a {
+				below += w
+			}
+			if x >= a {
+				above += w
+			}
+		}
+		return below / total, above / total
+	}
+	// solve finds the odds ratio at which fn is target by bisection on the
+	// log scale; fn must increase with the log odds ratio.
+	solve := func(fn func(logOR float64) float64, target float64) float64 {
+		l, u := -50.0, 50.0
+		for i := 0; i < 200; i++ {
+			m := (l + u) / 2
+			if fn(m) < target {
+				l = m
+			} else {
+				u = m
+			}
+		}
+		return math.Exp((l + u) / 2)
+	}
+	lower, upper = 0, math.Inf(1)
+	if a > lo {
+		lower = solve(func(logOR float64) float64 {
+			_, above := tails(logOR)
+			return above
+		}, 0.025)
+	}
+	if a < hi {
+		upper = solve(func(logOR float64) float64 {
+			below, _ := tails(logOR)
+			return -below
+		}, -0.025)
+	}
+	return lower, upper
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_TwoByTwo(t *testing.T) {
+	tests := []struct {
+		Table                  TwoByTwo
+		Haldane                bool
+		OR, ORLower, ORUpper   float64
+		RR, RRLower, RRUpper   float64
+		ExactLower, ExactUpper float64
+	}{
+		{
+			// R's fisher.test gives 0.2117 to 621.9, with a coarser root
+			// search.
+			Table:      TwoByTwo{3, 1, 1, 3},
+			OR:         9,
+			ORLower:    0.366637,
+			ORUpper:    220.927007,
+			RR:         3,
+			RRLower:    0.501284,
+			RRUpper:    17.953883,
+			ExactLower: 0.211736,
+			ExactUpper: 626.243531,
+		},
+		{
+			Table:      TwoByTwo{10, 20, 5, 40},
+			OR:         4,
+			ORLower:    1.204502,
+			ORUpper:    13.283495,
+			RR:         3,
+			RRLower:    1.138350,
+			RRUpper:    7.906178,
+			ExactLower: 1.051666,
+			ExactUpper: 16.711202,
+		},
+		{
+			Table:      TwoByTwo{0, 5, 3, 4},
+			Haldane:    true,
+			OR:         0.116883,
+			ORLower:    0.004696,
+			ORUpper:    2.909126,
+			RR:         0.190476,
+			RRLower:    0.011963,
+			RRUpper:    3.032695,
+			ExactLower: 0,
+			ExactUpper: 3.181941,
+		},
+	}
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-5*math.Max(1, math.Abs(b))
+	}
+	for i, test := range tests {
+		table, haldane := test.Table.Haldane()
+		if haldane != test.Haldane {
+			t.Errorf("test %d: got haldane %t", i, haldane)
+		}
+		or, lower, upper := table.OddsRatio()
+		if !near(or, test.OR) || !near(lower, test.ORLower) || !near(upper, test.ORUpper) {
+			t.Errorf("test %d: got OR %f (%f, %f)", i, or, lower, upper)
+		}
+		rr, lower, upper := table.RiskRatio()
+		if !near(rr, test.RR) || !near(lower, test.RRLower) || !near(upper, test.RRUpper) {
+			t.Errorf("test %d: got RR %f (%f, %f)", i, rr, lower, upper)
+		}
+		lower, upper = test.Table.ExactOddsRatioCI()
+		if !near(lower, test.ExactLower) || !near(upper, test.ExactUpper) {
+			t.Errorf("test %d: got exact (%f, %f)", i, lower, upper)
+		}
+	}
+	if _, upper := (TwoByTwo{3, 0, 1, 3}).ExactOddsRatioCI(); !math.IsInf(upper, 1) {
+		t.Errorf("want infinite upper bound, got %f", upper)
+	}
+	if lower, _ := (TwoByTwo{1.5, 1, 1, 3}).ExactOddsRatioCI(); !math.IsNaN(lower) {
+		t.Errorf("want NaN for weighted counts, got %f", lower)
+	}
+}
+
+func Test_Contingency_TwoByTwo(t *testing.T) {
+	c := Contingency{
+		Top:    []string{"MS", "GK"},
+		Left:   []string{"positiv", "negativ"},
+		Counts: [][]float64{{10, 5}, {20, 40}},
+	}
+	tests := []struct {
+		Effect  Effect
+		Want    TwoByTwo
+		WantErr bool
+	}{
+		{Effect: Effect{"MS", "positiv"}, Want: TwoByTwo{10, 20, 5, 40}},
+		{Effect: Effect{"GK", "negativ"}, Want: TwoByTwo{40, 5, 20, 10}},
+		{Effect: Effect{"MS", "n/a"}, WantErr: true},
+		{Effect: Effect{"XX", "positiv"}, WantErr: true},
+	}
+	for i, test := range tests {
+		got, err := c.TwoByTwo(test.Effect)
+		if (err != nil) != test.WantErr {
+			t.Errorf("test %d: got err %v", i, err)
+		} else if got != test.Want {
+			t.Errorf("test %d: got %+v, want %+v", i, got, test.Want)
+		}
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := WriteContingencyStats(w, c, &Effect{"MS", "positiv"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\nOdds Ratio,4\n") || !strings.Contains(buf.String(), "\nHaldane-Korrektur,no\n") {
+		t.Errorf("got %s", buf.String())
+	}
+	wide := Contingency{Top: []string{"a", "b", "c"}, Left: c.Left, Counts: [][]float64{{1, 2, 3}, {3, 2, 1}}}
+	if err := WriteContingencyStats(w, wide, &Effect{"a", "positiv"}); err == nil {
+		t.Errorf("want error for 2x3 table")
+	}
+}
+
+func Test_ParseEffects(t *testing.T) {
+	effects, err := ParseEffects("IgG-MS-GK-Matched=MS:positiv, Tabelle-IgG-Zentrum-Unmatched=Berlin:negativ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effects) != 2 || effects["IgG-MS-GK-Matched"] != (Effect{"MS", "positiv"}) {
+		t.Errorf("got %v", effects)
+	}
+	for _, val := range []string{"IgG-MS-GK-Matched", "IgG-MS-GK-Matched=MS", "IgG-MS-GK-Matched=:positiv"} {
+		if _, err := ParseEffects(val); err == nil {
+			t.Errorf("want error for %s", val)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import "math"
+
+// hungarian solves the rectangular assignment problem for the given cost
+// matrix. It returns the column assigned to each row, or -1 for rows that
+// stay unassigned because there are more rows than columns. Every column is
+// used at most once and the sum of the assigned costs is minimal.
+func hungarian(cost [][]float64) []int {
+	n := len(cost)
+	if n == 0 {
+		return nil
+	}
+	m := len(cost[0])
+	if n > m {
+		transposed := make([][]float64, m)
+		for j := range transposed {
+			transposed[j] = make([]float64, n)
+			for i := range cost {
+				transposed[j][i] = cost[i][j]
+			}
+		}
+		result := make([]int, n)
+		for i := range result {
+			result[i] = -1
+		}
+		for j, i := range hungarian(transposed) {
+			if i > -1 {
+				result[i] = j
+			}
+		}
+		return result
+	}
+
+	// Shortest augmenting path variant with row potentials u and column
+	// potentials v. Rows and columns are 1-indexed, column 0 is a sentinel
+	// that holds the row currently being inserted.
+	u := make([]float64, n+1)
+	v := make([]float64, m+1)
+	p := make([]int, m+1)
+	way := make([]int, m+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, m+1)
+		used := make([]bool, m+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+	result := make([]int, n)
+	for i := range result {
+		result[i] = -1
+	}
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 {
+			result[p[j]-1] = j - 1
+		}
+	}
+	return result
+}
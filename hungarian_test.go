@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func Test_hungarian(t *testing.T) {
+	tests := []struct {
+		Cost [][]float64
+		Want []int
+	}{
+		{
+			Cost: [][]float64{{1}},
+			Want: []int{0},
+		},
+		{
+			// Greedy would give row 0 column 0 and leave row 1 with a cost
+			// of 10.
+			Cost: [][]float64{
+				{1, 2},
+				{1, 10},
+			},
+			Want: []int{1, 0},
+		},
+		{
+			Cost: [][]float64{
+				{4, 1, 6},
+				{2, 0, 5},
+			},
+			Want: []int{1, 0},
+		},
+		{
+			Cost: [][]float64{
+				{4, 2},
+				{1, 3},
+				{0, 9},
+			},
+			Want: []int{1, -1, 0},
+		},
+	}
+	for i, test := range tests {
+		got := hungarian(test.Cost)
+		if len(got) != len(test.Want) {
+			t.Errorf("test %d: want %v, got %v", i, test.Want, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != test.Want[j] {
+				t.Errorf("test %d: want %v, got %v", i, test.Want, got)
+				break
+			}
+		}
+	}
+}
+
+func Test_hungarian_BruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		n, m := 1+r.Intn(5), 1+r.Intn(5)
+		cost := make([][]float64, n)
+		for row := range cost {
+			cost[row] = make([]float64, m)
+			for col := range cost[row] {
+				cost[row][col] = float64(r.Intn(20))
+			}
+		}
+		got := assignmentCost(cost, hungarian(cost))
+		want := bruteForceAssignment(cost, 0, make([]bool, m))
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("test %d: %v: want cost %f, got %f", i, cost, want, got)
+		}
+	}
+}
+
+func assignmentCost(cost [][]float64, assignment []int) float64 {
+	var sum float64
+	for i, j := range assignment {
+		if j > -1 {
+			sum += cost[i][j]
+		}
+	}
+	return sum
+}
+
+// bruteForceAssignment returns the minimal cost of assigning min(rows, cols)
+// rows to distinct columns.
+func bruteForceAssignment(cost [][]float64, row int, used []bool) float64 {
+	free := 0
+	for _, u := range used {
+		if !u {
+			free++
+		}
+	}
+	if row == len(cost) || free == 0 {
+		return 0
+	}
+	best := math.Inf(1)
+	// Leaving a row out is only allowed if there are more rows than columns
+	// left.
+	if len(cost)-row > free {
+		best = bruteForceAssignment(cost, row+1, used)
+	}
+	for j := range used {
+		if used[j] {
+			continue
+		}
+		used[j] = true
+		best = math.Min(best, cost[row][j]+bruteForceAssignment(cost, row+1, used))
+		used[j] = false
+	}
+	return best
+}
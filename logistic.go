@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// LogisticFit is the result of a logistic regression. The first coefficient
+// is the intercept, the others belong to the columns of the design matrix
+// in order.
+type LogisticFit struct {
+	Coefficients  []float64
+	StdErrors     []float64
+	LogLikelihood float64
+	Iterations    int
+}
+
+// FitLogistic fits a logistic regression of y, which must be 0 or 1, on the
+// rows of x using Newton-Raphson.
+func FitLogistic(x [][]float64, y []float64) (*LogisticFit, error) {
+	if len(x) != len(y) {
+		return nil, fmt.Errorf("bug: %d rows but %d outcomes", len(x), len(y))
+	}
+	if len(x) == 0 {
+		return nil, fmt.Errorf("no observations")
+	}
+	k := len(x[0]) + 1
+	design := make([][]float64, len(x))
+	for i, row := range x {
+		design[i] = append([]float64{1}, row...)
+	}
+	const (
+		maxIterations = 50
+		tolerance     = 1e-8
+	)
+	beta := make([]float64, k)
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		gradient := make([]float64, k)
+		hessian := newMatrix(k, k)
+		for i, row := range design {
+			p := logistic(dot(row, beta))
+			for a := range row {
+				gradient[a] += row[a] * (y[i] - p)
+				for b := range row {
+					hessian[a][b] += row[a] * row[b] * p * (1 - p)
+				}
+			}
+		}
+		inv, err := invert(hessian)
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %s", iteration, err)
+		}
+		step := mulVec(inv, gradient)
+		maxStep := 0.0
+		for j := range beta {
+			beta[j] += step[j]
+			maxStep = math.Max(maxStep, math.Abs(step[j]))
+		}
+		if maxStep < tolerance {
+			fit := &LogisticFit{Coefficients: beta, Iterations: iteration}
+			for j := range beta {
+				fit.StdErrors = append(fit.StdErrors, math.Sqrt(inv[j][j]))
+			}
+			for i, row := range design {
+				p := logistic(dot(row, beta))
+				fit.LogLikelihood += y[i]*math.Log(p) + (1-y[i])*math.Log(1-p)
+			}
+			return fit, nil
+		}
+	}
+	return nil, fmt.Errorf("no convergence after %d iterations, the outcome may be separated by the covariates", maxIterations)
+}
+
+func logistic(v float64) float64 {
+	return 1 / (1 + math.Exp(-v))
+}
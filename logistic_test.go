@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_FitLogistic(t *testing.T) {
+	// With a single binary covariate the maximum likelihood estimates are
+	// known in closed form: the intercept is the log odds among the
+	// unexposed, the slope is the log odds ratio.
+	var (
+		x [][]float64
+		y []float64
+	)
+	cells := []struct {
+		X, Y  float64
+		Count int
+	}{
+		{1, 1, 12},
+		{1, 0, 8},
+		{0, 1, 5},
+		{0, 0, 15},
+	}
+	for _, c := range cells {
+		for i := 0; i < c.Count; i++ {
+			x = append(x, []float64{c.X})
+			y = append(y, c.Y)
+		}
+	}
+	fit, err := FitLogistic(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{math.Log(5.0 / 15), math.Log(12.0 * 15 / (8 * 5))}
+	wantSE := []float64{math.Sqrt(1.0/5 + 1.0/15), math.Sqrt(1.0/12 + 1.0/8 + 1.0/5 + 1.0/15)}
+	for i := range want {
+		if math.Abs(fit.Coefficients[i]-want[i]) > 1e-6 {
+			t.Errorf("coefficient %d: want %f, got %f", i, want[i], fit.Coefficients[i])
+		}
+		if math.Abs(fit.StdErrors[i]-wantSE[i]) > 1e-6 {
+			t.Errorf("std error %d: want %f, got %f", i, wantSE[i], fit.StdErrors[i])
+		}
+	}
+}
+
+func Test_FitLogistic_Separation(t *testing.T) {
+	x := [][]float64{{1}, {2}, {3}, {4}}
+	y := []float64{0, 0, 1, 1}
+	if _, err := FitLogistic(x, y); err == nil {
+		t.Errorf("want error for separated outcome, got nil")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Mahalanobis measures the distance between subjects over several numeric
+// covariates, taking their scales and correlations into account.
+type Mahalanobis struct {
+	Covariates []Covariate
+	// InvCovariance is the inverse of the covariance matrix of the
+	// covariates.
+	InvCovariance [][]float64
+}
+
+// NewMahalanobis estimates the covariance of the covariates from all
+// subjects that have a value for each of them.
+func NewMahalanobis(subjects []*Subject, covariates []Covariate) (*Mahalanobis, error) {
+	m := &Mahalanobis{Covariates: covariates}
+	var rows [][]float64
+	for _, s := range subjects {
+		if row, ok := m.row(s); ok {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("need at least 2 subjects with complete covariates, got %d", len(rows))
+	}
+	k := len(covariates)
+	means := make([]float64, k)
+	for _, row := range rows {
+		for i, v := range row {
+			means[i] += v / float64(len(rows))
+		}
+	}
+	cov := newMatrix(k, k)
+	for _, row := range rows {
+		for i := range row {
+			for j := range row {
+				cov[i][j] += (row[i] - means[i]) * (row[j] - means[j]) / float64(len(rows)-1)
+			}
+		}
+	}
+	inv, err := invert(cov)
+	if err != nil {
+		return nil, fmt.Errorf("covariance of %d subjects: %s", len(rows), err)
+	}
+	m.InvCovariance = inv
+	return m, nil
+}
+
+func (m *Mahalanobis) row(s *Subject) ([]float64, bool) {
+	row := make([]float64, len(m.Covariates))
+	for i, c := range m.Covariates {
+		v, ok := c.Value(s)
+		if !ok {
+			return nil, false
+		}
+		row[i] = v
+	}
+	return row, true
+}
+
+// Distance returns the Mahalanobis distance between a and b.
+func (m *Mahalanobis) Distance(a, b *Subject) (float64, bool) {
+	ra, okA := m.row(a)
+	rb, okB := m.row(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	diff := make([]float64, len(ra))
+	for i := range ra {
+		diff[i] = ra[i] - rb[i]
+	}
+	return math.Sqrt(math.Max(0, dot(diff, mulVec(m.InvCovariance, diff)))), true
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_Mahalanobis(t *testing.T) {
+	age, _ := CovariateByName("Age")
+	subjects := testSubjects(GK, Female, 20, 30, 40, 50)
+	m, err := NewMahalanobis(subjects, []Covariate{age})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With a single covariate the distance is the difference in standard
+	// deviations.
+	_, variance := meanVariance([]float64{20, 30, 40, 50})
+	want := 10 / math.Sqrt(variance)
+	got, ok := m.Distance(subjects[0], subjects[1])
+	if !ok || math.Abs(got-want) > 1e-9 {
+		t.Errorf("want %f, got %f (ok=%t)", want, got, ok)
+	}
+
+	// Perfectly correlated covariates have a singular covariance matrix.
+	if _, err := NewMahalanobis(subjects, []Covariate{age, age}); err == nil {
+		t.Errorf("want error for singular covariance, got nil")
+	}
+}
@@ -4,12 +4,10 @@ import (
 	"encoding/csv"
 	"flag"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,11 +15,127 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		runValidate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "list-reports" {
+		runListReports(os.Args[2:])
+		return
+	}
 	start := time.Now()
+	matchMethod := flag.String("match", string(Greedy), "Matching method for controls and cases: greedy, optimal or cem")
+	cemBins := flag.String("cem-bins", "Age=5", "Comma separated covariate=width (or covariate:width) bins for -match=cem")
+	matchRatio := flag.Int("ratio", 1, "Number of cases matched to each control")
+	variableRatio := flag.Bool("variable-ratio", false, "Keep controls that received fewer than -ratio cases")
+	replacement := flag.Bool("replacement", false, "Match with replacement, i.e. a case can be matched to several controls")
+	ageCaliper := flag.Float64("age-caliper", 0, "Maximum age difference in years between matched subjects, 0 to disable")
+	sickDurationCaliper := flag.Float64("sick-duration-caliper", 0, "Maximum Erkrankungsdauer difference between matched subjects, 0 to disable")
+	exact := flag.String("exact", "Gender", "Comma separated categories that controls and cases are matched on exactly")
+	mappingFile := flag.String("mapping", "", "JSON file that maps input columns to subject fields, defaults to the built-in mapping")
+	printMapping := flag.Bool("print-mapping", false, "Print the built-in column mapping as JSON and exit")
+	ageSource := flag.String("age", string(SheetAge), "Ages used for matching: sheet, or computed from Geburtsdatum and the sampling and onset dates")
+	tablesFlag := flag.String("tables", "", "Comma separated row:column category pairs to write contingency tables for, e.g. IgG:Diagnosis")
+	groupValuesFlag := flag.String("group-values", "", "Comma separated covariate:category pairs to list the covariate values per category level for, e.g. IgGTiter:SMRT_GD")
+	pseudonyms := flag.String("pseudonyms", string(SequentialPseudonyms), "How study IDs replace patient identities in the outputs: sequential or hmac")
+	pseudonymKey := flag.String("pseudonym-key", "", "File with the secret key for -pseudonyms=hmac")
+	keyFile := flag.String("key-file", "Pseudonyme.csv", "File to write the pseudonym key to, must not be inside the output dir")
+	identifying := flag.Bool("identifying", false, "Keep names, birth dates and lab numbers in the outputs instead of only the pseudonyms")
+	mcNemarFlag := flag.String("mc-nemar", "", "Comma separated binary covariates to run McNemar's test on in the matched pairs, in addition to IgG=0. Subjects coded 1 count as exposed, unless the name is followed by =0, e.g. IgM=0")
+	effectsFlag := flag.String("effects", "", "Comma separated table=column:row entries that set the exposed column and outcome row for odds and risk ratios of 2x2 tables, e.g. IgG-MS-GK-Matched=MS:positiv")
+	clrCovariates := flag.String("clr-covariates", "IgGTiter,Nikotinabusus", "Covariates of the conditional logistic regression of being a case in the matched sets")
+	onlyFlag := flag.String("only", "", "Comma separated reports to write, defaults to all, see ./main list-reports, which takes the same -tables, -group-values and -mc-nemar")
+	excludeFlag := flag.String("exclude", "", "Comma separated reports not to write")
+	sheet := flag.String("sheet", "", "Worksheet to read from an .xlsx input file, defaults to the first one")
+	seed := flag.Int64("seed", 0, "Shuffle subjects with this seed before matching to break ties reproducibly, 0 keeps the input order")
+	stabilityRuns := flag.Int("stability-runs", 0, "Repeat the matching with this many different seeds and report how stable each pair is")
+	msMatchMethod := flag.String("ms-match", string(Greedy), "Matching method for IgG positive and negative MS patients: greedy or optimal")
+	msExact := flag.String("ms-exact", "Gender", "Comma separated categories that IgG positive and negative MS patients are matched on exactly")
+	distance := flag.String("distance", "age", "Distance for matching controls and cases: age, propensity or mahalanobis")
+	msDistance := flag.String("ms-distance", "legacy", "Distance for matching IgG positive and negative MS patients: legacy, propensity or mahalanobis")
+	psCovariates := flag.String("ps-covariates", "Age,Gender", "Covariates of the propensity score for -distance=propensity")
+	msPSCovariates := flag.String("ms-ps-covariates", "Age,SickDuration", "Covariates of the propensity score for -ms-distance=propensity")
+	psCaliper := flag.Float64("ps-caliper", 0.2, "Maximum logit propensity score difference in standard deviations, 0 to disable")
+	mahalanobisCovariates := flag.String("mahalanobis-covariates", "Age", "Covariates of the Mahalanobis distance for -distance=mahalanobis")
+	msMahalanobisCovariates := flag.String("ms-mahalanobis-covariates", "Age,SickDuration", "Covariates of the Mahalanobis distance for -ms-distance=mahalanobis")
+	mahalanobisCaliper := flag.Float64("mahalanobis-caliper", 0, "Maximum Mahalanobis distance between matched subjects, 0 to disable")
 	flag.Usage = func() {
-		fatalf("./main <input.csv> <outputDir>")
+		fmt.Fprintf(os.Stderr, "./main [flags] <input.csv|input.xlsx> <outputDir>\n")
+		fmt.Fprintf(os.Stderr, "./main validate [flags] <input.csv|input.xlsx>\n")
+		fmt.Fprintf(os.Stderr, "./main list-reports [flags]\n")
+		flag.PrintDefaults()
+		os.Exit(1)
 	}
 	flag.Parse()
+	if *printMapping {
+		data, err := DefaultMapping.JSON()
+		if err != nil {
+			fatalf("%s", err)
+		}
+		fmt.Printf("%s\n", data)
+		return
+	}
+	method, err := ParseMatchMethod(*matchMethod)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	mapping := DefaultMapping
+	if *mappingFile != "" {
+		if mapping, err = ReadMapping(*mappingFile); err != nil {
+			fatalf("%s", err)
+		}
+	}
+	RegisterVariables(mapping.Variables)
+	registry := DefaultReports()
+	if err := registry.RegisterFlagReports(*tablesFlag, *groupValuesFlag, *mcNemarFlag); err != nil {
+		fatalf("%s", err)
+	}
+	effects, err := ParseEffects(*effectsFlag)
+	if err != nil {
+		fatalf("effects: %s", err)
+	}
+	only := ParseReportNames(*onlyFlag)
+	reports, err := registry.Select(only, ParseReportNames(*excludeFlag))
+	if err != nil {
+		fatalf("%s", err)
+	}
+	needed := NeededInputs(reports)
+	clr, err := ParseCovariates(*clrCovariates)
+	if err != nil {
+		fatalf("clr-covariates: %s", err)
+	}
+	pseudonymMethod, err := ParsePseudonymMethod(*pseudonyms)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	var pseudonymSecret []byte
+	if *pseudonymKey != "" {
+		if pseudonymSecret, err = ReadPseudonymKey(*pseudonymKey); err != nil {
+			fatalf("%s", err)
+		}
+	} else if pseudonymMethod == HMACPseudonyms {
+		fatalf("-pseudonyms=hmac needs -pseudonym-key")
+	}
+	source, err := ParseAgeSource(*ageSource)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	msMethod, err := ParseMatchMethod(*msMatchMethod)
+	if err != nil || msMethod == CEM {
+		fatalf("Bad ms-match: %s", *msMatchMethod)
+	}
+	strata, err := ParseStrata(*exact)
+	if err != nil {
+		fatalf("exact: %s", err)
+	}
+	msStrata, err := ParseStrata(*msExact)
+	if err != nil {
+		fatalf("ms-exact: %s", err)
+	}
+	coarsenings, err := ParseCoarsenings(*cemBins)
+	if err != nil {
+		fatalf("cem-bins: %s", err)
+	}
 	inputFile := flag.Arg(0)
 	if inputFile == "" {
 		flag.Usage()
@@ -32,515 +146,192 @@ func main() {
 	} else if outputDir == "." {
 		fmt.Printf("Output dir must not be working directory")
 	}
+	if inside, err := insideDir(*keyFile, outputDir); err != nil {
+		fatalf("key-file: %s", err)
+	} else if inside {
+		fatalf("key-file: %s must not be inside the output dir %s", *keyFile, outputDir)
+	}
 	readStart := time.Now()
-	subjects, err := readSubjects(inputFile)
-	if err != nil {
+	subjects, warnings, err := readSubjects(inputFile, *sheet, mapping)
+	if len(warnings) > 0 {
+		fmt.Fprintf(os.Stderr, "%d warnings:\n", len(warnings))
+		warnings.WriteTable(os.Stderr)
+	}
+	if errs, ok := err.(ValidationErrors); ok {
+		errs.WriteTable(os.Stderr)
+		errFile := filepath.Join(outputDir, "csv", "Validierungsfehler.csv")
+		if !*identifying {
+			errs = errs.Redact()
+		}
+		if err := errs.WriteFile(errFile); err != nil {
+			fatalf("Could not write %s: %s", errFile, err)
+		}
+		fatalf("readSubjects: %s, see %s", err, errFile)
+	} else if err != nil {
 		fatalf("readSubjects: %s", err)
 	}
 	fmt.Printf("readSubjects: %s\n", time.Since(readStart))
+	for name, e := range effects {
+		if err := registry.SetEffect(name, e, subjects); err != nil {
+			fatalf("effects: %s", err)
+		}
+	}
+	ageMismatches := AgeMismatches(subjects)
+	if len(ageMismatches) > 0 {
+		fmt.Printf("%d ages differ from the ones computed from dates, see Alter-Abgleich\n", len(ageMismatches))
+	}
+	if source == ComputedAge {
+		if kept := UseComputedAges(subjects); kept > 0 {
+			fmt.Printf("%d subjects lack dates, their ages are taken from the sheet\n", kept)
+		}
+	}
 	matchStart := time.Now()
-	matched, matchedAgeDiffs := match(subjects)
-	fmt.Printf("match: %s\n", time.Since(matchStart))
-	msMatched := subjects.Match(func(a, b *Subject) float64 {
-		if a.Diagnosis == GK || b.Diagnosis == GK {
-			return 0
+	matchOpts := MatchOptions{
+		Method:              method,
+		Strata:              strata,
+		Ratio:               *matchRatio,
+		VariableRatio:       *variableRatio,
+		AgeCaliper:          *ageCaliper,
+		SickDurationCaliper: *sickDurationCaliper,
+		Coarsenings:         coarsenings,
+		Seed:                *seed,
+		Replacement:         *replacement,
+	}
+	var psModel *PropensityModel
+	switch *distance {
+	case "age":
+	case "propensity":
+		covariates, err := ParseCovariates(*psCovariates)
+		if err != nil {
+			fatalf("ps-covariates: %s", err)
 		}
-		if a.Gender != b.Gender {
-			return 0
+		psModel, err = FitPropensity(subjects, covariates, func(s *Subject) bool {
+			return s.Diagnosis != GK
+		})
+		if err != nil {
+			fatalf("FitPropensity: %s", err)
 		}
-		if a.IgG == b.IgG {
-			return 0
+		matchOpts.Distance = psModel.Distance
+		matchOpts.Caliper = *psCaliper * psModel.LogitSD(subjects)
+	case "mahalanobis":
+		covariates, err := ParseCovariates(*mahalanobisCovariates)
+		if err != nil {
+			fatalf("mahalanobis-covariates: %s", err)
 		}
-		if a.SickDuration == nil || b.SickDuration == nil {
-			return 0
+		m, err := NewMahalanobis(subjects, covariates)
+		if err != nil {
+			fatalf("NewMahalanobis: %s", err)
 		}
-		if *a.SickDuration < 0 || *b.SickDuration < 0 {
-			return 0
+		matchOpts.Distance = m.Distance
+		matchOpts.Caliper = *mahalanobisCaliper
+	default:
+		fatalf("Unknown distance: %s", *distance)
+	}
+	var (
+		matched   MatchedSets
+		unmatched []Unmatched
+	)
+	if needed[MatchedInput] {
+		matched, unmatched = match(subjects, matchOpts)
+		fmt.Printf("match: %s\n", time.Since(matchStart))
+	}
+	var (
+		msScore    = legacyMSScore
+		msPSModel  *PropensityModel
+		msPatients Subjects
+	)
+	for _, s := range subjects {
+		if s.Diagnosis != GK {
+			msPatients = append(msPatients, s)
 		}
-		ageDiff := math.Abs(a.Age - b.Age)
-		if ageDiff > 3 {
-			return 0
+	}
+	switch *msDistance {
+	case "legacy":
+	case "propensity":
+		covariates, err := ParseCovariates(*msPSCovariates)
+		if err != nil {
+			fatalf("ms-ps-covariates: %s", err)
 		}
-		sdDiff := math.Abs(*a.SickDuration - *b.SickDuration)
-		if sdDiff > 1 {
-			return 0
+		msPSModel, err = FitPropensity(msPatients, covariates, func(s *Subject) bool {
+			return bool(s.IgG)
+		})
+		if err != nil {
+			fatalf("FitPropensity: %s", err)
 		}
-		return 1 / sdDiff
-	})
+		msScore = msDistanceScore(msPSModel.Distance, *psCaliper*msPSModel.LogitSD(msPatients))
+	case "mahalanobis":
+		covariates, err := ParseCovariates(*msMahalanobisCovariates)
+		if err != nil {
+			fatalf("ms-mahalanobis-covariates: %s", err)
+		}
+		m, err := NewMahalanobis(msPatients, covariates)
+		if err != nil {
+			fatalf("NewMahalanobis: %s", err)
+		}
+		msScore = msDistanceScore(m.Distance, *mahalanobisCaliper)
+	default:
+		fatalf("Unknown ms-distance: %s", *msDistance)
+	}
+	matchMS := func(s Subjects) []Match {
+		if msMethod == Optimal {
+			return s.MatchOptimal(msStrata.Restrict(msScore))
+		}
+		return s.Match(msStrata.Restrict(msScore))
+	}
+	var msMatched []Match
+	if needed[MSMatchedInput] {
+		msMatched = matchMS(subjects.Shuffled(*seed))
+	}
+	var stability, msStability *PairStability
+	if *stabilityRuns > 0 && needed[StabilityInput] {
+		stabilityStart := time.Now()
+		stability, msStability = &PairStability{}, &PairStability{}
+		for _, runSeed := range stabilitySeeds(*seed, *stabilityRuns) {
+			runOpts := matchOpts
+			runOpts.Seed = runSeed
+			runMatched, _ := match(subjects, runOpts)
+			stability.Add(runMatched.pairKeys())
+			msStability.Add(matchKeys(matchMS(subjects.Shuffled(runOpts.Seed))))
+		}
+		fmt.Printf("stability: %s\n", time.Since(stabilityStart))
+	}
 
-	outputFiles := map[string]func(w *csv.Writer) error{
-		"Patienten-MS-Toxo-Matched-EDSS": func(w *csv.Writer) error {
-			header := []string{"Toxo-IgG Positiv", "Toxo-IgG Negativ"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			results := map[Status][]float64{}
-			for _, m := range msMatched {
-				if m.A.EDSS == nil || m.B.EDSS == nil {
-					continue
-				}
-				for _, s := range append(Subjects{}, m.A, m.B) {
-					results[s.IgG] = append(results[s.IgG], *s.EDSS)
-				}
-			}
-			groups := []Status{true, false}
-			for i := 0; ; i++ {
-				row := make([]string, len(groups))
-				found := false
-				for j, group := range groups {
-					vals := results[group]
-					if i < len(vals) {
-						found = true
-						row[j] = fmt.Sprintf("%f", vals[i])
-					}
-				}
-				if !found {
-					break
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"Patienten-MS-Toxo-Matched": func(w *csv.Writer) error {
-			header := []string{"Row", "Name", "Geschlecht", "Alter", "Erkrankungsdauer", "IgG", "Diagnose", "Geburtsdatum", "EDSS", "CMRT_T2", "CMRT_GD", "SMRT_T2", "SMRT_GD", "Match Score"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for i, match := range msMatched {
-				for j, s := range append(Subjects{}, match.A, match.B) {
-					row := []string{
-						fmt.Sprintf("%d", i*2+j+1),
-						fmt.Sprintf("%s %s", s.FirstName, s.LastName),
-						fmt.Sprintf("%s", s.Gender),
-						fmt.Sprintf("%f", s.Age),
-						fmt.Sprintf("%f", *s.SickDuration),
-						fmt.Sprintf("%s", s.IgG),
-						fmt.Sprintf("%s", s.Diagnosis),
-						fmt.Sprintf("%s", s.Birthday),
-						fmt.Sprintf("%s", float64PtrStr(s.EDSS)),
-						fmt.Sprintf("%s", s.CMRT_T2),
-						fmt.Sprintf("%s", s.CMRT_GD),
-						fmt.Sprintf("%s", s.SMRT_T2),
-						fmt.Sprintf("%s", s.SMRT_GD),
-						fmt.Sprintf("%.2f", match.Score),
-					}
-					if err := w.Write(row); err != nil {
-						return err
-					}
-				}
-			}
-			return nil
-		},
-		"Patienten": func(w *csv.Writer) error {
-			return writeSubjects(w, subjects)
-		},
-		"Patienten-Matched": func(w *csv.Writer) error {
-			return writeSubjects(w, matched)
-		},
-		"Patienten-Matched-Altersunterschied": func(w *csv.Writer) error {
-			return writeHistogram(w, matchedAgeDiffs)
-		},
-		"IgG-MS-GK-Unmatched": func(w *csv.Writer) error {
-			top := []string{"MS", "GK"}
-			left := []string{"positiv", "negativ"}
-			return WriteContingency(w, top, left, IgG_MS_GKSubjects(subjects))
-		},
-		"IgG-MS-GK-Matched": func(w *csv.Writer) error {
-			top := []string{"MS", "GK"}
-			left := []string{"positiv", "negativ"}
-			return WriteContingency(w, top, left, IgG_MS_GKSubjects(matched))
-		},
-		"IgM-MS-GK-Unmatched": func(w *csv.Writer) error {
-			top := []string{"MS", "GK"}
-			left := []string{"positiv", "negativ"}
-			return WriteContingency(w, top, left, IgM_MS_GKSubjects(subjects))
-		},
-		"IgM-MS-GK-Matched": func(w *csv.Writer) error {
-			top := []string{"MS", "GK"}
-			left := []string{"positiv", "negativ"}
-			return WriteContingency(w, top, left, IgM_MS_GKSubjects(matched))
-		},
-		"ANA-Nikotinabusus-MS": func(w *csv.Writer) error {
-			top := []string{"ja", "nein"}
-			left := []string{"positiv", "negativ"}
-			return WriteContingency(w, top, left, ANA_Nikotinabusus_MS_Subjects(subjects))
-		},
-		"IgG-Treatment": func(w *csv.Writer) error {
-			type result struct {
-				Positive int
-				Negative int
-			}
-			results := map[TherapyGroup]result{}
-			groups := []TherapyGroup{Untreated, BaseMedication, EscalationTherapy}
-			for _, s := range subjects {
-				g := s.TherapyGroup()
-				if g == TherapyNA {
-					continue
-				}
-				r := results[g]
-				if s.IgG {
-					r.Positive++
-				} else {
-					r.Negative++
-				}
-				results[g] = r
-			}
-			header := []string{"", "Positiv", "Negativ"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for _, g := range groups {
-				r := results[g]
-				row := []string{
-					string(g),
-					fmt.Sprintf("%d", r.Positive),
-					fmt.Sprintf("%d", r.Negative),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"IgG-Titer-IgG-Gesamt": func(w *csv.Writer) error {
-			header := []string{"IgG Gesamt", "IgG Titer"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for _, s := range subjects {
-				if s.IgGTotal == nil {
-					continue
-				}
-				row := []string{
-					fmt.Sprintf("%f", *s.IgGTotal),
-					fmt.Sprintf("%f", s.IgGTiter),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"IgG-Titer-Erkrankungsdauer": func(w *csv.Writer) error {
-			header := []string{"Erkrankungsdauer", "IgG Titer"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for _, s := range subjects {
-				if s.SickDuration == nil {
-					continue
-				}
-				if *s.SickDuration < 0 {
-					continue
-				}
-				row := []string{
-					fmt.Sprintf("%f", *s.SickDuration),
-					fmt.Sprintf("%f", s.IgGTiter),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"EDSS": func(w *csv.Writer) error {
-			header := []string{"EDSS"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for _, s := range subjects {
-				if s.EDSS == nil {
-					continue
-				}
-				row := []string{
-					fmt.Sprintf("%f", *s.EDSS),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"IgG-Titer-EDSS": func(w *csv.Writer) error {
-			header := []string{"EDSS", "IgG Titer"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for _, s := range subjects {
-				if s.EDSS == nil {
-					continue
-				}
-				row := []string{
-					fmt.Sprintf("%f", *s.EDSS),
-					fmt.Sprintf("%f", s.IgGTiter),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"IgG-Titer-Alter": func(w *csv.Writer) error {
-			header := []string{"Alter", "IgG Titer"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			for _, s := range subjects {
-				row := []string{
-					fmt.Sprintf("%f", s.Age),
-					fmt.Sprintf("%f", s.IgGTiter),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"IgG-Titer-SMRT-GD": func(w *csv.Writer) error {
-			groups := []Group{
-				NASPositiv,
-				NASNegativ,
-				NASNA,
-			}
-			groupSubjects := make([]GroupSubject, len(subjects))
-			for i, s := range subjects {
-				groupSubjects[i] = SMRT_GDSubject{s}
-			}
-			return WriteGroupValues(w, groups, groupSubjects)
-		},
-		"IgG-Titer-CMRT-GD": func(w *csv.Writer) error {
-			groups := []Group{
-				NASPositiv,
-				NASNegativ,
-				NASNA,
-			}
-			groupSubjects := make([]GroupSubject, len(subjects))
-			for i, s := range subjects {
-				groupSubjects[i] = CMRT_GDSubject{s}
-			}
-			return WriteGroupValues(w, groups, groupSubjects)
-		},
-		"CMRT-T2-Counts": func(w *csv.Writer) error {
-			header := []string{""}
-			for _, dia := range Diagnoses {
-				header = append(header, string(dia))
-			}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			vals := []string{"0", "<6", ">=6", "n/a"}
-			for _, val := range vals {
-				results := []string{val}
-				for _, dia := range Diagnoses {
-					count := 0
-					for _, s := range matched {
-						if s.Diagnosis != dia {
-							continue
-						}
-						v := s.CMRT_T2.String()
-						if v != val {
-							continue
-						}
-						count++
-					}
-					results = append(results, fmt.Sprintf("%d", count))
-				}
-				if err := w.Write(results); err != nil {
-					return err
-				}
-			}
-			return nil
-			//countS := []string{}
-			//sum := 0
-			//for _, count := range counts {
-			//sum += count
-			//countS = append(countS, fmt.Sprintf("%d", count))
-			//}
-			//percents := []string{}
-			//for _, count := range counts {
-			//percents = append(percents, fmt.Sprintf("%.2f", float64(count)/float64(sum)*100))
-			//}
-			//if err := w.Write(countS); err != nil {
-			//return err
-			//}
-			//return w.Write(percents)
-		},
-		"IgG-Titer-CMRT-T2": func(w *csv.Writer) error {
-			groups := []Group{
-				NewNARelInt(NewRelInt(Eq, 0), false),
-				NewNARelInt(NewRelInt(Lt, 6), false),
-				NewNARelInt(NewRelInt(GtEq, 6), false),
-				NewNARelInt(RelInt{}, true),
-			}
-			groupSubjects := make([]GroupSubject, len(subjects))
-			for i, s := range subjects {
-				groupSubjects[i] = CMRTSubject{s}
-			}
-			return WriteGroupValues(w, groups, groupSubjects)
-		},
-		"IgG-Titer-SMRT-T2": func(w *csv.Writer) error {
-			groups := []Group{
-				NewNARelInt(NewRelInt(Eq, 0), false),
-				NewNARelInt(NewRelInt(Lt, 3), false),
-				NewNARelInt(NewRelInt(GtEq, 3), false),
-				NewNARelInt(RelInt{}, true),
-			}
-			groupSubjects := make([]GroupSubject, len(subjects))
-			for i, s := range subjects {
-				groupSubjects[i] = SMRTSubject{s}
-			}
-			return WriteGroupValues(w, groups, groupSubjects)
-		},
-		"Nikotinabusus-MS-GK-Unmatched": func(w *csv.Writer) error {
-			header := []string{"Nikotinabusus", "GK", "MS"}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			type result struct {
-				GK int
-				MS int
-			}
-			results := map[YesNoNA]result{}
-			for _, s := range subjects {
-				r := results[s.Nikotinabusus]
-				if s.Diagnosis == GK {
-					r.GK++
-				} else {
-					r.MS++
-				}
-				results[s.Nikotinabusus] = r
-			}
-			rowTitles := []YesNoNA{Yes, No, NA}
-			for _, title := range rowTitles {
-				row := []string{
-					string(title),
-					fmt.Sprintf("%d", results[title].GK),
-					fmt.Sprintf("%d", results[title].MS),
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		"IgG-Titer-Nikotinabusus": func(w *csv.Writer) error {
-			header := []string{}
-			groups := []YesNoNA{Yes, No}
-			for _, group := range groups {
-				header = append(header, string(group))
-			}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			results := map[YesNoNA][]float64{}
-			for _, s := range subjects {
-				if s.Nikotinabusus == NA {
-					continue
-				}
-				results[s.Nikotinabusus] = append(results[s.Nikotinabusus], s.IgGTiter)
-			}
-			i := 0
-			for {
-				row := make([]string, len(Diagnoses))
-				found := false
-				for g, group := range groups {
-					if i < len(results[group]) {
-						found = true
-						row[g] = fmt.Sprintf("%f", results[group][i])
-					}
-				}
-				if !found {
-					break
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-				i++
-			}
-			return nil
-		},
-		"IgG-Titer-Unmatched": func(w *csv.Writer) error {
-			header := []string{}
-			for _, group := range Diagnoses {
-				header = append(header, string(group))
-			}
-			if err := w.Write(header); err != nil {
-				return err
-			}
-			results := map[Diagnosis][]float64{}
-			for _, s := range subjects {
-				results[s.Diagnosis] = append(results[s.Diagnosis], s.IgGTiter)
-			}
-			i := 0
-			for {
-				row := make([]string, len(Diagnoses))
-				found := false
-				for g, group := range Diagnoses {
-					if i < len(results[group]) {
-						found = true
-						row[g] = fmt.Sprintf("%f", results[group][i])
-					}
-				}
-				if !found {
-					break
-				}
-				if err := w.Write(row); err != nil {
-					return err
-				}
-				i++
-			}
-			return nil
-		},
-		"IgG-MS-GK-Matched-Mc-Nemar": func(w *csv.Writer) error {
-			results := struct {
-				NoYes  int
-				YesNo  int
-				YesYes int
-				NoNo   int
-			}{}
-			i := 0
-			for i < len(matched) {
-				controlSubject, caseSubject := matched[i], matched[i+1]
-				if controlSubject.IgG && !caseSubject.IgG {
-					results.NoYes++
-				} else if !controlSubject.IgG && caseSubject.IgG {
-					results.YesNo++
-				} else if !controlSubject.IgG && !caseSubject.IgG {
-					results.YesYes++
-				} else if controlSubject.IgG && caseSubject.IgG {
-					results.NoNo++
-				}
-				i += 2
-			}
-			if err := w.Write([]string{"Control Risk Factor", "Case Risk Factor", "Count"}); err != nil {
-				return err
-			}
-			rows := []struct {
-				ControlRisk bool
-				CaseRisk    bool
-				Count       int
-			}{
-				{false, true, results.NoYes},
-				{true, false, results.YesNo},
-				{true, true, results.YesYes},
-				{false, false, results.NoNo},
-			}
-			for _, row := range rows {
-				rowStr := []string{
-					fmt.Sprintf("%s", yesNo(row.ControlRisk)),
-					fmt.Sprintf("%s", yesNo(row.CaseRisk)),
-					fmt.Sprintf("%d", row.Count),
-				}
-				if err := w.Write(rowStr); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
+	in := &Inputs{
+		Subjects:      subjects,
+		MSPatients:    msPatients,
+		AgeMismatches: ageMismatches,
+		Mapping:       mapping,
+		Matched:       matched,
+		Unmatched:     unmatched,
+		Strata:        strata,
+		MSMatched:     msMatched,
+		MSStrata:      msStrata,
+		Stability:     stability,
+		MSStability:   msStability,
+		PSModel:       psModel,
+		MSPSModel:     msPSModel,
+		CLRCovariates: clr,
+	}
+	if err := AssignPseudonyms(subjects, pseudonymMethod, pseudonymSecret); err != nil {
+		fatalf("AssignPseudonyms: %s", err)
+	}
+	if err := writeCSVFile(*keyFile, 0600, func(w *csv.Writer) error {
+		return WritePseudonymKey(w, subjects)
+	}); err != nil {
+		fatalf("Could not write %s: %s", *keyFile, err)
+	}
+	fmt.Printf("Pseudonym key: %s, do not share it\n", *keyFile)
+	if !*identifying {
+		StripIdentities(subjects)
 	}
-	for name, fn := range outputFiles {
+	for _, report := range reports {
+		if missing, ok := in.Missing(report); ok {
+			if len(only) > 0 {
+				fmt.Printf("Skipping %s: no %s input in this run\n", report.Name(), missing)
+			}
+			continue
+		}
+		name := report.Name()
 		outputs := []string{"csv", "prism"}
 		for i, output := range outputs {
 			start := time.Now()
@@ -554,14 +345,19 @@ func main() {
 				fatalf("Could not open output file: %s", err)
 			}
 			defer outFile.Close()
+			records, err := RenderReport(report, in, *identifying)
+			if records == nil {
+				fatalf("Could not render %s: %s", outPath, err)
+			} else if err != nil {
+				fmt.Printf("Failed to write %s: %s\n", outPath, err)
+			}
 			w := csv.NewWriter(outFile)
 			if output == "prism" {
 				w.Comma = '\t'
 			}
-			if err := fn(w); err != nil {
+			if err := w.WriteAll(records); err != nil {
 				fmt.Printf("Failed to write %s: %s\n", outPath, err)
 			}
-			w.Flush()
 			if i == 0 {
 				fmt.Printf("%s: %s\n", name, time.Since(start))
 			}
@@ -570,6 +366,51 @@ func main() {
 	fmt.Printf("Total: %s\n", time.Since(start))
 }
 
+// msCandidates returns true if a and b may be paired by the MS matching, which
+// compares IgG positive and negative patients.
+func msCandidates(a, b *Subject) bool {
+	if a.Diagnosis == GK || b.Diagnosis == GK {
+		return false
+	}
+	return a.IgG != b.IgG
+}
+
+// msDistanceScore returns a score for Subjects.Match that only pairs
+// msCandidates, preferring those that are close according to d.
+func msDistanceScore(d Distance, caliper float64) func(a, b *Subject) float64 {
+	score := d.Score(caliper)
+	return func(a, b *Subject) float64 {
+		if !msCandidates(a, b) {
+			return 0
+		}
+		return score(a, b)
+	}
+}
+
+// legacyMSScore is the original score of the MS matching. It only accepts
+// pairs within 3 years of age and 1 month of sick duration, and prefers
+// pairs with a similar sick duration.
+func legacyMSScore(a, b *Subject) float64 {
+	if !msCandidates(a, b) {
+		return 0
+	}
+	if a.SickDuration == nil || b.SickDuration == nil {
+		return 0
+	}
+	if *a.SickDuration < 0 || *b.SickDuration < 0 {
+		return 0
+	}
+	ageDiff := math.Abs(a.Age - b.Age)
+	if ageDiff > 3 {
+		return 0
+	}
+	sdDiff := math.Abs(*a.SickDuration - *b.SickDuration)
+	if sdDiff > 1 {
+		return 0
+	}
+	return 1 / sdDiff
+}
+
 func yesNo(v bool) string {
 	if v {
 		return "yes"
@@ -597,7 +438,56 @@ func writeHistogram(w *csv.Writer, h Histogram) error {
 }
 
 func writeSubjects(w *csv.Writer, subjects []*Subject) error {
+	if err := w.Write(subjectHeader()); err != nil {
+		return err
+	}
+	for _, s := range subjects {
+		if err := w.Write(subjectRow(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMatchedSets writes the subjects of each set, prefixed with the number
+// and stratum of the set, and the role and weight of the subject.
+func writeMatchedSets(w *csv.Writer, sets MatchedSets) error {
+	header := append([]string{"Set", "Stratum", "Rolle", "Gewicht"}, subjectHeader()...)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	weights := sets.Weights()
+	for i, set := range sets {
+		for _, s := range set.Subjects() {
+			prefix := []string{fmt.Sprintf("%d", i+1), set.Stratum, s.Role(), float64Str(weights[s])}
+			row := append(prefix, subjectRow(s)...)
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeUnmatched writes the subjects that were left out by match(), together
+// with the reason why.
+func writeUnmatched(w *csv.Writer, unmatched []Unmatched) error {
+	header := append([]string{"Rolle", "Grund"}, subjectHeader()...)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, u := range unmatched {
+		row := append([]string{u.Subject.Role(), u.Explanation()}, subjectRow(u.Subject)...)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subjectHeader() []string {
 	header := []string{
+		"Pseudonym",
 		"Labor- Berlin Nr.",
 		"Probennummer",
 		"Vorname",
@@ -622,138 +512,138 @@ func writeSubjects(w *csv.Writer, subjects []*Subject) error {
 		"IgM",
 		"IgG Titer",
 	}
-	if err := w.Write(header); err != nil {
-		return err
+	for _, v := range Variables {
+		header = append(header, v.Name)
 	}
-	for _, s := range subjects {
-		var ageEM string
-		if s.AgeEM != nil {
-			ageEM = fmt.Sprintf("%f", *s.AgeEM)
-		}
-		var sickDuration string
-		if s.SickDuration != nil {
-			sickDuration = fmt.Sprintf("%f", *s.SickDuration)
-		}
-		var edss string
-		if s.EDSS != nil {
-			edss = fmt.Sprintf("%f", *s.EDSS)
-		}
-		var qIgG string
-		if s.QIgG != nil {
-			qIgG = fmt.Sprintf("%f", *s.QIgG)
-		}
-		var numRelapse string
-		if s.NumRelapse != nil {
-			numRelapse = fmt.Sprintf("%f", *s.NumRelapse)
-		}
-		var IgGTotal string
-		if s.IgGTotal != nil {
-			IgGTotal = fmt.Sprintf("%f", *s.IgGTotal)
-		}
-		row := []string{
-			s.LabBerlinNumber,
-			s.ProbeNumber,
-			s.FirstName,
-			s.LastName,
-			string(s.Gender),
-			string(s.Diagnosis),
-			fmt.Sprintf("%s", s.IgG),
-			fmt.Sprintf("%f", s.Age),
-			ageEM,
-			sickDuration,
-			edss,
-			qIgG,
-			string(s.Nikotinabusus),
-			string(s.TherapyGroup()),
-			numRelapse,
-			s.CMRT_T2.String(),
-			s.SMRT_T2.String(),
-			s.CMRT_GD.String(),
-			s.SMRT_GD.String(),
-			s.ANA.String(),
-			IgGTotal,
-			s.IgM.String(),
-			fmt.Sprintf("%f", s.IgGTiter),
-		}
-		if err := w.Write(row); err != nil {
-			return err
-		}
-	}
-	return nil
+	return header
 }
 
-func match(subjects []*Subject) (results []*Subject, ageDiffs Histogram) {
-	var (
-		ops      = 0
-		controls []*Subject
-		cases    []*Subject
-	)
-	for _, subject := range subjects {
-		ops++
-		if subject.Diagnosis == GK {
-			controls = append(controls, subject)
-		} else {
-			cases = append(cases, subject)
-		}
+func subjectRow(s *Subject) []string {
+	var ageEM string
+	if s.AgeEM != nil {
+		ageEM = fmt.Sprintf("%f", *s.AgeEM)
 	}
-	for _, controlSubject := range controls {
-		bestMatch := -1
-		for i, caseSubject := range cases {
-			ops++
-			if caseSubject.Gender != controlSubject.Gender {
-				continue
-			}
-			if bestMatch == -1 {
-				bestMatch = i
-				continue
-			}
-			currentAgeDiff := math.Abs(caseSubject.Age - controlSubject.Age)
-			bestAgeDiff := math.Abs(cases[bestMatch].Age - controlSubject.Age)
-			if currentAgeDiff < bestAgeDiff {
-				bestMatch = i
-			}
-		}
-		ageDiff := math.Abs(cases[bestMatch].Age - controlSubject.Age)
-		ageDiffs = append(ageDiffs, ageDiff)
-		if bestMatch > -1 {
-			results = append(results, controlSubject, cases[bestMatch])
-			cases = append(cases[0:bestMatch], cases[bestMatch+1:]...)
-		}
+	var sickDuration string
+	if s.SickDuration != nil {
+		sickDuration = fmt.Sprintf("%f", *s.SickDuration)
+	}
+	var edss string
+	if s.EDSS != nil {
+		edss = fmt.Sprintf("%f", *s.EDSS)
+	}
+	var qIgG string
+	if s.QIgG != nil {
+		qIgG = fmt.Sprintf("%f", *s.QIgG)
+	}
+	var numRelapse string
+	if s.NumRelapse != nil {
+		numRelapse = fmt.Sprintf("%f", *s.NumRelapse)
+	}
+	var IgGTotal string
+	if s.IgGTotal != nil {
+		IgGTotal = fmt.Sprintf("%f", *s.IgGTotal)
+	}
+	row := []string{
+		s.Pseudonym,
+		s.LabBerlinNumber,
+		s.ProbeNumber,
+		s.FirstName,
+		s.LastName,
+		string(s.Gender),
+		string(s.Diagnosis),
+		fmt.Sprintf("%s", s.IgG),
+		fmt.Sprintf("%f", s.Age),
+		ageEM,
+		sickDuration,
+		edss,
+		qIgG,
+		string(s.Nikotinabusus),
+		string(s.TherapyGroup()),
+		numRelapse,
+		s.CMRT_T2.String(),
+		s.SMRT_T2.String(),
+		s.CMRT_GD.String(),
+		s.SMRT_GD.String(),
+		s.ANA.String(),
+		IgGTotal,
+		s.IgM.String(),
+		fmt.Sprintf("%f", s.IgGTiter),
+	}
+	for _, v := range Variables {
+		row = append(row, s.Values[v.Name].Text)
 	}
-	return
+	return row
+}
+
+// Histogram is a list of values with a frequency weight each. Nil Weights
+// give every value a weight of 1.
+type Histogram struct {
+	Values  []float64
+	Weights []float64
 }
 
-type Histogram []float64
+func (h Histogram) weight(i int) float64 {
+	if h.Weights == nil {
+		return 1
+	}
+	return h.Weights[i]
+}
+
+// Add appends v with weight w.
+func (h *Histogram) Add(v, w float64) {
+	h.Values = append(h.Values, v)
+	h.Weights = append(h.Weights, w)
+}
 
 func (h Histogram) Min() float64 {
-	var min float64
-	for _, val := range h {
-		min = math.Min(min, val)
+	min := math.NaN()
+	for i, val := range h.Values {
+		if i == 0 || val < min {
+			min = val
+		}
 	}
 	return min
 }
 
 func (h Histogram) Max() float64 {
-	var max float64
-	for _, val := range h {
-		max = math.Max(max, val)
+	max := math.NaN()
+	for i, val := range h.Values {
+		if i == 0 || val > max {
+			max = val
+		}
 	}
 	return max
 }
 
 func (h Histogram) Mean() float64 {
-	var sum float64
-	for _, val := range h {
-		sum += val
-	}
-	return sum / float64(len(h))
+	mean, _ := weightedMeanVariance(h.Values, h.Weights)
+	return mean
 }
 
+// Median returns the weighted median, i.e. the value at which half of the
+// total weight is reached. If that happens exactly between two values, their
+// mean is returned.
 func (h Histogram) Median() float64 {
-	if len(h)%2 == 0 {
-		return (h[len(h)/2-1] + h[len(h)/2]) / 2
+	order := make([]int, len(h.Values))
+	var total float64
+	for i := range order {
+		order[i] = i
+		total += h.weight(i)
+	}
+	slice.Sort(order, func(i, j int) bool {
+		return h.Values[order[i]] < h.Values[order[j]]
+	})
+	var sum float64
+	for k, i := range order {
+		sum += h.weight(i)
+		if sum > total/2 {
+			return h.Values[i]
+		}
+		if sum == total/2 && k+1 < len(order) {
+			return (h.Values[i] + h.Values[order[k+1]]) / 2
+		}
 	}
-	return h[(len(h)-1)/2]
+	return math.NaN()
 }
 
 func (h Histogram) String() string {
@@ -766,180 +656,202 @@ func (h Histogram) String() string {
 	)
 }
 
-func readSubjects(file string) (Subjects, error) {
-	iconv := exec.Command("iconv", "-f", "utf-16", "-t", "utf-8", file)
-	stdout, err := iconv.StdoutPipe()
+// readSubjects reads the subjects from a tab separated text export or, for
+// .xlsx files, from the given sheet of the workbook.
+func readSubjects(file, sheet string, mapping Mapping) (Subjects, ValidationErrors, error) {
+	rows, err := readRows(file, sheet)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if err := iconv.Start(); err != nil {
+	return parseSubjects(rows, mapping)
+}
+
+// readRows returns all rows of file, including any rows above the header.
+func readRows(file, sheet string) ([][]string, error) {
+	if strings.EqualFold(filepath.Ext(file), ".xlsx") {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := readXLSX(f, info.Size(), sheet)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		return rows, nil
+	}
+	if sheet != "" {
+		return nil, fmt.Errorf("%s: sheet %s given, but the file is not .xlsx", file, sheet)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		stdout.Close()
-		iconv.Wait()
-	}()
-	r := csv.NewReader(stdout)
+	text, _, err := decodeText(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	r := csv.NewReader(strings.NewReader(text))
 	r.Comma = '\t'
-	columns, err := r.Read()
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
+
+// findHeader returns the index of the first row that contains one of names.
+// The header row doesn't have to be the first row of a sheet.
+func findHeader(rows [][]string, names []string) (int, error) {
+	for i, row := range rows {
+		for _, cell := range row {
+			for _, name := range names {
+				if strings.TrimSpace(cell) == name {
+					return i, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("No header row with column %s found", strings.Join(names, " or "))
+}
+
+// parseSubjects maps the rows below the header row to subjects. It checks
+// every row and returns all problems as ValidationErrors, not only the first.
+// Cells that were ignored, e.g. a bad OptionalDateField, are returned as
+// warnings.
+func parseSubjects(rows [][]string, mapping Mapping) (Subjects, ValidationErrors, error) {
+	header, err := findHeader(rows, mapping.HeaderNames())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	subjects := Subjects{}
-	for {
-		row, err := r.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
+	columns := rows[header]
+	for i, c := range columns {
+		columns[i] = strings.TrimSpace(c)
+	}
+	var errs, warnings ValidationErrors
+	missing := func(c Column) {
+		errs = append(errs, ValidationError{
+			Line:     header + 1,
+			Column:   c.Column,
+			Expected: "Spalte " + strings.Join(c.Names(), " oder "),
+			Message:  "Unknown column: " + c.Column,
+		})
+	}
+	skip := -1
+	if mapping.Skip.Column != "" {
+		if i, ok := mapping.Skip.index(columns); ok {
+			skip = i
+		} else {
+			missing(mapping.Skip)
 		}
-		get := func(column string) (string, error) {
-			for i, c := range columns {
-				if column == c {
-					return row[i], nil
-				}
+	}
+	fieldColumns := map[string]int{}
+	for _, f := range mapping.Fields {
+		i, ok := f.index(columns)
+		if !ok {
+			if f.Required {
+				missing(f.Column)
 			}
-			return "", fmt.Errorf("Unknown column: %s", column)
+			i = -1
 		}
-		badRow, err := get("nicht verwendbar")
-		if err != nil {
-			return nil, err
+		fieldColumns[f.Field] = i
+	}
+	variableColumns := map[string]int{}
+	for _, v := range mapping.Variables {
+		i, ok := v.index(columns)
+		if !ok {
+			if v.Required {
+				missing(v.Column)
+			}
+			i = -1
 		}
-		if strings.TrimSpace(badRow) != "" {
+		variableColumns[v.Name] = i
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	subjects := Subjects{}
+	for n, row := range rows[header+1:] {
+		line := header + n + 2
+		cell := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return row[i]
+		}
+		if strings.TrimSpace(cell(skip)) != "" {
 			continue
 		}
-		s := &Subject{}
-		apply := func(mapping map[string]interface{}) error {
-			for column, dst := range mapping {
-				val, err := get(column)
-				if err != nil {
-					return err
+		s := &Subject{Line: line}
+		valid := true
+		apply := func(key bool) {
+			for _, f := range mapping.Fields {
+				if mapping.isKey(f.Field) != key {
+					continue
 				}
-				val = strings.TrimSpace(val)
-				switch t := dst.(type) {
-				case *string:
-					*t = val
-				case *Status:
-					switch val {
-					case "positiv":
-						*t = true
-					case "negativ":
-						*t = false
-					default:
-						return fmt.Errorf("Invalid Status: %s", val)
-					}
-				case *NAStatus:
-					p, err := ParseNAStatus(val)
-					if err != nil {
-						return err
-					}
-					*t = p
-				case *Diagnosis:
-					found := false
-					for _, group := range Diagnoses {
-						if string(group) == val {
-							*t = Diagnosis(val)
-							found = true
-							break
-						}
-					}
-					if !found {
-						return fmt.Errorf("Invalid Group: %s", val)
-					}
-				case *Gender:
-					found := false
-					for _, gender := range Genders {
-						if string(gender) == val {
-							*t = Gender(val)
-							found = true
-							break
-						}
-					}
-					if !found {
-						return fmt.Errorf("Invalid Gender: %s", val)
-					}
-				case *YesNoNA:
-					switch YesNoNA(strings.ToLower((val))) {
-					case Yes:
-						*t = Yes
-					case No:
-						*t = No
-					default:
-						*t = NA
-					}
-				case *NARelInt:
-					if val == "" {
-						*t = NewNARelInt(RelInt{}, true)
-						continue
-					}
-					p, err := ParseNARelInt(val)
-					if err != nil {
-						return fmt.Errorf("Bad NARelInt: %s", err)
-					}
-					*t = p
-				case *float64:
-					val = strings.Replace(val, ",", ".", -1)
-					f, err := strconv.ParseFloat(val, 64)
-					if err != nil {
-						return err
-					}
-					*t = f
-				case **float64:
-					val = strings.Replace(val, ",", ".", -1)
-					f, err := strconv.ParseFloat(val, 64)
-					if err != nil {
-						*t = nil
-					} else {
-						*t = &f
-					}
-				default:
-					return fmt.Errorf("Bad dst type: %#v", dst)
+				val := cell(fieldColumns[f.Field])
+				dst := subjectField(s, f.Field)
+				if err := parseValue(f.Type, dst, val); err != nil {
+					valid = false
+					errs = append(errs, ValidationError{
+						Line:     line,
+						Column:   f.Column.Column,
+						Field:    f.Field,
+						Value:    val,
+						Expected: f.Type.Expected(),
+						Message:  err.Error(),
+					})
+				} else if d, ok := dst.(**time.Time); ok && *d == nil && strings.TrimSpace(val) != "" {
+					warnings = append(warnings, ValidationError{
+						Line:     line,
+						Column:   f.Column.Column,
+						Field:    f.Field,
+						Value:    val,
+						Expected: f.Type.Expected(),
+						Message:  "Bad date, treated as unknown",
+					})
 				}
 			}
-			return nil
 		}
-		initialMapping := map[string]interface{}{
-			"Probennummer":      &s.ProbeNumber,
-			"Labor- Berlin Nr.": &s.LabBerlinNumber,
-			"Vorname":           &s.FirstName,
-			"Nachname":          &s.LastName,
-			"Geburtsdatum":      &s.Birthday,
-		}
-		if err := apply(initialMapping); err != nil {
-			return nil, fmt.Errorf("%s: %s", err, row)
+		apply(true)
+		empty := true
+		for _, key := range mapping.Key {
+			if strings.TrimSpace(cell(fieldColumns[key])) != "" {
+				empty = false
+			}
 		}
-		if s.ProbeNumber == "" && s.LabBerlinNumber == "" {
+		if empty {
 			// ignore empty row
 			continue
 		}
-		remainingMapping := map[string]interface{}{
-			"Alter (PE)":                          &s.Age,
-			"Gruppe":                              &s.Diagnosis,
-			"Geschlecht":                          &s.Gender,
-			"IgG":                                 &s.IgG,
-			"IgM":                                 &s.IgM,
-			"IgG titer (IU/ml)":                   &s.IgGTiter,
-			"Nikotinabusus":                       &s.Nikotinabusus,
-			"Basismedikation":                     &s.BaseMedication,
-			"Eskalationstherapie":                 &s.EscalationTherapy,
-			"EDSS":                                &s.EDSS,
-			"Alter (EM)":                          &s.AgeEM,
-			"Erkrankungsdauer (Monate)":           &s.SickDuration,
-			"Q (CSF/Serum) IgG":                   &s.QIgG,
-			"Anzahl der Schübe":                   &s.NumRelapse,
-			"cMRT: n-Läsionen T2-Statistik neu":   &s.CMRT_T2,
-			"sMRT: n-Läsionen T2-Statistik neu 3": &s.SMRT_T2,
-			"cMRT Gd":                    &s.CMRT_GD,
-			"sMRT Gd":                    &s.SMRT_GD,
-			"IgG mg/dl Serum (700-1600)": &s.IgGTotal,
-			"ANA <1/80":                  &s.ANA,
+		apply(false)
+		if len(mapping.Variables) > 0 {
+			s.Values = map[string]Value{}
+		}
+		for _, v := range mapping.Variables {
+			val := cell(variableColumns[v.Name])
+			parsed, err := v.parse(val)
+			if err != nil {
+				valid = false
+				errs = append(errs, ValidationError{
+					Line:     line,
+					Column:   v.Column.Column,
+					Value:    val,
+					Expected: v.expected(),
+					Message:  err.Error(),
+				})
+			}
+			s.Values[v.Name] = parsed
 		}
-		if err := apply(remainingMapping); err != nil {
-			return nil, fmt.Errorf("%s: %s", s, err)
+		if valid {
+			subjects = append(subjects, s)
 		}
-		subjects = append(subjects, s)
 	}
-	return subjects, nil
+	if len(errs) > 0 {
+		return subjects, warnings, errs
+	}
+	return subjects, warnings, nil
 }
 
 type Gender string
@@ -1022,7 +934,16 @@ type Subject struct {
 	CMRT_GD           NAStatus
 	SMRT_GD           NAStatus
 	ANA               NAStatus
-	Birthday          string
+	Birthday          *time.Time
+	SamplingDate      *time.Time
+	OnsetDate         *time.Time
+	// Line is the line or sheet row the subject was read from.
+	Line int
+	// Values holds the Variables of the mapping by name.
+	Values map[string]Value
+	// Pseudonym is the study ID that replaces the identity in outputs.
+	Pseudonym string
+	stripped  bool
 }
 
 type Subjects []*Subject
@@ -1056,13 +977,74 @@ func (s Subjects) Match(scoreFn func(a, b *Subject) float64) []Match {
 			matches = append(matches, best)
 		}
 	}
+	sortMatches(matches)
+	return matches
+}
+
+// MatchOptimal pairs subjects like Match, but maximizes the total score of
+// all pairs instead of letting each subject pick its best partner in turn.
+// Pairs with a score <= 0 are never formed. Scores must be finite.
+func (s Subjects) MatchOptimal(scoreFn func(a, b *Subject) float64) []Match {
+	type candidate struct {
+		i, j  int
+		score float64
+	}
+	var (
+		candidates []candidate
+		maxScore   float64
+	)
+	for i := range s {
+		for j := i + 1; j < len(s); j++ {
+			score := scoreFn(s[i], s[j])
+			if math.IsInf(score, -1) || math.IsNaN(score) {
+				panic(fmt.Errorf("bug: bad score of %s and %s: %f", s[i], s[j], score))
+			}
+			if score <= 0 {
+				continue
+			}
+			candidates = append(candidates, candidate{i: i, j: j, score: score})
+			if !math.IsInf(score, 1) {
+				maxScore = math.Max(maxScore, score)
+			}
+		}
+	}
+	// The blossom algorithm works on integer weights, so the scores are
+	// scaled to a range that keeps plenty of precision. An infinite score,
+	// e.g. 1/0 of legacyMSScore, outweighs any matching of finite scores,
+	// just like it always wins in Subjects.Match.
+	const scale = 1e9
+	inf := int64(len(s)/2+1) * scale
+	var edges []weightedEdge
+	for _, c := range candidates {
+		w := inf
+		if !math.IsInf(c.score, 1) {
+			w = int64(math.Max(1, math.Floor(c.score/maxScore*scale+0.5)))
+		}
+		edges = append(edges, weightedEdge{I: c.i, J: c.j, W: w})
+	}
+	mate := maxWeightMatching(edges)
+	scores := map[[2]int]float64{}
+	for _, c := range candidates {
+		scores[[2]int{c.i, c.j}] = c.score
+	}
+	matches := []Match{}
+	for i, j := range mate {
+		if j > i {
+			matches = append(matches, Match{A: s[i], B: s[j], Score: scores[[2]int{i, j}], i: i, j: j})
+		}
+	}
+	sortMatches(matches)
+	return matches
+}
+
+// sortMatches sorts matches by descending score.
+func sortMatches(matches []Match) {
 	slice.Sort(matches, func(i, j int) bool {
 		if matches[i].A == matches[j].A || matches[i].B == matches[j].B || matches[i].A == matches[j].B {
 			panic(fmt.Errorf("bug: broken invariant: %d: %#v | %d: %#v", i, matches[i], j, matches[j]))
 		}
 		return matches[i].Score > matches[j].Score
 	})
-	return matches
 }
 
 func (s *Subject) TherapyGroup() TherapyGroup {
@@ -1084,6 +1066,14 @@ func (s *Subject) TherapyGroup() TherapyGroup {
 	}
 }
 
+// Role returns the part s plays in match(), either "Kontrolle" or "Fall".
+func (s *Subject) Role() string {
+	if s.Diagnosis == GK {
+		return "Kontrolle"
+	}
+	return "Fall"
+}
+
 func float64PtrStr(v *float64) string {
 	if v == nil {
 		return "n/a"
@@ -1092,6 +1082,9 @@ func float64PtrStr(v *float64) string {
 }
 
 func (s *Subject) String() string {
+	if s.stripped {
+		return s.Pseudonym
+	}
 	return fmt.Sprintf("<%s,%s,%s>", s.FirstName, s.LastName, s.ProbeNumber)
 }
 
@@ -1108,6 +1101,10 @@ func (s CMRTSubject) String() string {
 	return fmt.Sprintf("%f", s.IgGTiter)
 }
 
+func (s CMRTSubject) Value() float64 {
+	return s.IgGTiter
+}
+
 func (s CMRTSubject) Group() Group {
 	return s.CMRT_T2
 }
@@ -1120,6 +1117,10 @@ func (s SMRTSubject) String() string {
 	return fmt.Sprintf("%f", s.IgGTiter)
 }
 
+func (s SMRTSubject) Value() float64 {
+	return s.IgGTiter
+}
+
 func (s SMRTSubject) Group() Group {
 	return s.SMRT_T2
 }
@@ -1132,6 +1133,10 @@ func (s SMRT_GDSubject) String() string {
 	return fmt.Sprintf("%f", s.IgGTiter)
 }
 
+func (s SMRT_GDSubject) Value() float64 {
+	return s.IgGTiter
+}
+
 func (s SMRT_GDSubject) Group() Group {
 	return s.SMRT_GD
 }
@@ -1144,6 +1149,10 @@ func (s CMRT_GDSubject) String() string {
 	return fmt.Sprintf("%f", s.IgGTiter)
 }
 
+func (s CMRT_GDSubject) Value() float64 {
+	return s.IgGTiter
+}
+
 func (s CMRT_GDSubject) Group() Group {
 	return s.CMRT_GD
 }
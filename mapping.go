@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mapping describes how the columns of the input map to Subject fields.
+type Mapping struct {
+	// Skip is a column that marks rows to ignore when it is not empty.
+	Skip Column `json:"skip"`
+	// Key are the fields that identify a subject. They are read first, and
+	// rows where all of them are empty are ignored.
+	Key    []string       `json:"key"`
+	Fields []FieldMapping `json:"fields"`
+	// Variables are read into Subject.Values, for parameters that have no
+	// Subject field.
+	Variables []Variable `json:"variables,omitempty"`
+}
+
+// Column is the name of a column and the other names it may have in older
+// or newer versions of the sheet.
+type Column struct {
+	Column  string   `json:"column"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Names returns the column name followed by its aliases.
+func (c Column) Names() []string {
+	return append([]string{c.Column}, c.Aliases...)
+}
+
+// index returns the position of the first of c.Names() found in columns.
+func (c Column) index(columns []string) (int, bool) {
+	for _, name := range c.Names() {
+		for i, column := range columns {
+			if column == name {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// FieldMapping maps a column to a Subject field.
+type FieldMapping struct {
+	// Field is the name of the Subject field, e.g. "IgGTiter".
+	Field string `json:"field"`
+	Column
+	Type FieldType `json:"type"`
+	// Required fields need their column in the input. A missing column of
+	// a field that is not required is treated like a column of empty cells.
+	Required bool `json:"required"`
+}
+
+// FieldType selects how the text of a cell is parsed.
+type FieldType string
+
+const (
+	// StringField is taken as is.
+	StringField FieldType = "string"
+	// StatusField is positiv or negativ.
+	StatusField FieldType = "status"
+	// NAStatusField is like StatusField, but may be unknown.
+	NAStatusField FieldType = "nastatus"
+	// DiagnosisField is one of Diagnoses.
+	DiagnosisField FieldType = "diagnosis"
+	// GenderField is one of Genders.
+	GenderField FieldType = "gender"
+	// YesNoField is ja or nein, anything else is NA.
+	YesNoField FieldType = "yesno"
+	// NARelIntField is a relative integer like <6, empty is NA.
+	NARelIntField FieldType = "narelint"
+	// NumberField is a number with a decimal point or comma.
+	NumberField FieldType = "number"
+	// OptionalNumberField is a NumberField that is missing if the cell
+	// can't be parsed, e.g. because it is empty or n/a.
+	OptionalNumberField FieldType = "optional-number"
+	// DateField is a date as accepted by ParseDate, or empty if unknown.
+	DateField FieldType = "date"
+	// OptionalDateField is a DateField that is unknown if the cell can't
+	// be parsed. parseSubjects warns about such cells.
+	OptionalDateField FieldType = "optional-date"
+)
+
+// DefaultMapping matches the columns of the lab's master sheet.
+var DefaultMapping = Mapping{
+	Skip: Column{Column: "nicht verwendbar"},
+	Key:  []string{"ProbeNumber", "LabBerlinNumber"},
+	Fields: []FieldMapping{
+		{Field: "ProbeNumber", Column: Column{Column: "Probennummer"}, Type: StringField, Required: true},
+		{Field: "LabBerlinNumber", Column: Column{Column: "Labor- Berlin Nr."}, Type: StringField, Required: true},
+		{Field: "FirstName", Column: Column{Column: "Vorname"}, Type: StringField, Required: true},
+		{Field: "LastName", Column: Column{Column: "Nachname"}, Type: StringField, Required: true},
+		{Field: "Birthday", Column: Column{Column: "Geburtsdatum"}, Type: OptionalDateField, Required: true},
+		{Field: "SamplingDate", Column: Column{Column: "Datum Probenentnahme", Aliases: []string{"Entnahmedatum", "Datum PE"}}, Type: DateField},
+		{Field: "OnsetDate", Column: Column{Column: "Datum Erstmanifestation", Aliases: []string{"Datum EM"}}, Type: DateField},
+		{Field: "Age", Column: Column{Column: "Alter (PE)"}, Type: NumberField, Required: true},
+		{Field: "Diagnosis", Column: Column{Column: "Gruppe"}, Type: DiagnosisField, Required: true},
+		{Field: "Gender", Column: Column{Column: "Geschlecht"}, Type: GenderField, Required: true},
+		{Field: "IgG", Column: Column{Column: "IgG"}, Type: StatusField, Required: true},
+		{Field: "IgM", Column: Column{Column: "IgM"}, Type: NAStatusField, Required: true},
+		{Field: "IgGTiter", Column: Column{Column: "IgG titer (IU/ml)"}, Type: NumberField, Required: true},
+		{Field: "Nikotinabusus", Column: Column{Column: "Nikotinabusus"}, Type: YesNoField, Required: true},
+		{Field: "BaseMedication", Column: Column{Column: "Basismedikation"}, Type: YesNoField, Required: true},
+		{Field: "EscalationTherapy", Column: Column{Column: "Eskalationstherapie"}, Type: YesNoField, Required: true},
+		{Field: "EDSS", Column: Column{Column: "EDSS"}, Type: OptionalNumberField, Required: true},
+		{Field: "AgeEM", Column: Column{Column: "Alter (EM)"}, Type: OptionalNumberField, Required: true},
+		{Field: "SickDuration", Column: Column{Column: "Erkrankungsdauer (Monate)"}, Type: OptionalNumberField, Required: true},
+		{Field: "QIgG", Column: Column{Column: "Q (CSF/Serum) IgG"}, Type: OptionalNumberField, Required: true},
+		{Field: "NumRelapse", Column: Column{Column: "Anzahl der Schübe"}, Type: OptionalNumberField, Required: true},
+		{Field: "CMRT_T2", Column: Column{Column: "cMRT: n-Läsionen T2-Statistik neu"}, Type: NARelIntField, Required: true},
+		{Field: "SMRT_T2", Column: Column{Column: "sMRT: n-Läsionen T2-Statistik neu 3"}, Type: NARelIntField, Required: true},
+		{Field: "CMRT_GD", Column: Column{Column: "cMRT Gd"}, Type: NAStatusField, Required: true},
+		{Field: "SMRT_GD", Column: Column{Column: "sMRT Gd"}, Type: NAStatusField, Required: true},
+		{Field: "IgGTotal", Column: Column{Column: "IgG mg/dl Serum (700-1600)"}, Type: OptionalNumberField, Required: true},
+		{Field: "ANA", Column: Column{Column: "ANA <1/80"}, Type: NAStatusField, Required: true},
+	},
+}
+
+// ReadMapping reads a JSON mapping file. Unknown keys are rejected, so typos
+// don't silently fall back to defaults.
+func ReadMapping(file string) (Mapping, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Mapping{}, err
+	}
+	var m Mapping
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&m); err != nil {
+		return Mapping{}, fmt.Errorf("Bad mapping: %s: %s", file, err)
+	}
+	if err := m.Validate(); err != nil {
+		return Mapping{}, fmt.Errorf("Bad mapping: %s: %s", file, err)
+	}
+	return m, nil
+}
+
+// Validate checks that every field exists, has a type that fits it and is
+// mapped only once, that the key fields are mapped, and that the variables
+// have unique names that don't clash with existing categories or
+// covariates.
+func (m Mapping) Validate() error {
+	mapped := map[string]bool{}
+	for _, f := range m.Fields {
+		dst := subjectField(&Subject{}, f.Field)
+		if dst == nil {
+			return fmt.Errorf("unknown field: %s", f.Field)
+		}
+		if mapped[f.Field] {
+			return fmt.Errorf("field mapped twice: %s", f.Field)
+		}
+		mapped[f.Field] = true
+		if f.Column.Column == "" {
+			return fmt.Errorf("no column for field %s", f.Field)
+		}
+		if !f.Type.fits(dst) {
+			return fmt.Errorf("type %q doesn't fit field %s", f.Type, f.Field)
+		}
+	}
+	if len(m.Key) == 0 {
+		return fmt.Errorf("no key fields")
+	}
+	for _, key := range m.Key {
+		if !mapped[key] {
+			return fmt.Errorf("key field not mapped: %s", key)
+		}
+	}
+	names := map[string]bool{}
+	for _, v := range m.Variables {
+		if err := v.validate(); err != nil {
+			return err
+		}
+		if names[v.Name] {
+			return fmt.Errorf("variable declared twice: %s", v.Name)
+		}
+		names[v.Name] = true
+	}
+	return nil
+}
+
+// JSON returns the mapping as indented JSON, e.g. as a starting point for a
+// custom mapping file.
+func (m Mapping) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// HeaderNames returns the names of the key columns, which identify the
+// header row of the input.
+func (m Mapping) HeaderNames() []string {
+	var names []string
+	for _, f := range m.Fields {
+		for _, key := range m.Key {
+			if f.Field == key {
+				names = append(names, f.Names()...)
+			}
+		}
+	}
+	return names
+}
+
+// ColumnName returns the column the field is read from, or the field name
+// if it isn't mapped.
+func (m Mapping) ColumnName(field string) string {
+	for _, f := range m.Fields {
+		if f.Field == field {
+			return f.Column.Column
+		}
+	}
+	return field
+}
+
+func (m Mapping) isKey(field string) bool {
+	for _, key := range m.Key {
+		if key == field {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectField returns a pointer to the field of s with the given name, or
+// nil if there is no such field.
+func subjectField(s *Subject, name string) interface{} {
+	fields := map[string]interface{}{
+		"ProbeNumber":       &s.ProbeNumber,
+		"LabBerlinNumber":   &s.LabBerlinNumber,
+		"FirstName":         &s.FirstName,
+		"LastName":          &s.LastName,
+		"Birthday":          &s.Birthday,
+		"SamplingDate":      &s.SamplingDate,
+		"OnsetDate":         &s.OnsetDate,
+		"Diagnosis":         &s.Diagnosis,
+		"Gender":            &s.Gender,
+		"IgG":               &s.IgG,
+		"IgM":               &s.IgM,
+		"IgGTiter":          &s.IgGTiter,
+		"IgGTotal":          &s.IgGTotal,
+		"QIgG":              &s.QIgG,
+		"Age":               &s.Age,
+		"AgeEM":             &s.AgeEM,
+		"SickDuration":      &s.SickDuration,
+		"Nikotinabusus":     &s.Nikotinabusus,
+		"BaseMedication":    &s.BaseMedication,
+		"EscalationTherapy": &s.EscalationTherapy,
+		"EDSS":              &s.EDSS,
+		"NumRelapse":        &s.NumRelapse,
+		"CMRT_T2":           &s.CMRT_T2,
+		"SMRT_T2":           &s.SMRT_T2,
+		"CMRT_GD":           &s.CMRT_GD,
+		"SMRT_GD":           &s.SMRT_GD,
+		"ANA":               &s.ANA,
+	}
+	return fields[name]
+}
+
+// fits returns true if t can parse into dst.
+func (t FieldType) fits(dst interface{}) bool {
+	switch dst.(type) {
+	case *string:
+		return t == StringField
+	case *Status:
+		return t == StatusField
+	case *NAStatus:
+		return t == NAStatusField
+	case *Diagnosis:
+		return t == DiagnosisField
+	case *Gender:
+		return t == GenderField
+	case *YesNoNA:
+		return t == YesNoField
+	case *NARelInt:
+		return t == NARelIntField
+	case *float64:
+		return t == NumberField
+	case **float64:
+		return t == OptionalNumberField
+	case **time.Time:
+		return t == DateField || t == OptionalDateField
+	}
+	return false
+}
+
+// parseValue parses val into dst, which has to be a pointer that t fits.
+func parseValue(t FieldType, dst interface{}, val string) error {
+	if !t.fits(dst) {
+		return fmt.Errorf("Bad dst type: %#v", dst)
+	}
+	val = strings.TrimSpace(val)
+	optional := t == OptionalDateField
+	switch t := dst.(type) {
+	case *string:
+		*t = val
+	case *Status:
+		switch val {
+		case "positiv":
+			*t = true
+		case "negativ":
+			*t = false
+		default:
+			return fmt.Errorf("Invalid Status: %s", val)
+		}
+	case *NAStatus:
+		p, err := ParseNAStatus(val)
+		if err != nil {
+			return err
+		}
+		*t = p
+	case *Diagnosis:
+		found := false
+		for _, group := range Diagnoses {
+			if string(group) == val {
+				*t = Diagnosis(val)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Invalid Group: %s", val)
+		}
+	case *Gender:
+		found := false
+		for _, gender := range Genders {
+			if string(gender) == val {
+				*t = Gender(val)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Invalid Gender: %s", val)
+		}
+	case *YesNoNA:
+		switch YesNoNA(strings.ToLower((val))) {
+		case Yes:
+			*t = Yes
+		case No:
+			*t = No
+		default:
+			*t = NA
+		}
+	case *NARelInt:
+		if val == "" {
+			*t = NewNARelInt(RelInt{}, true)
+			return nil
+		}
+		p, err := ParseNARelInt(val)
+		if err != nil {
+			return fmt.Errorf("Bad NARelInt: %s", err)
+		}
+		*t = p
+	case *float64:
+		f, err := strconv.ParseFloat(strings.Replace(val, ",", ".", -1), 64)
+		if err != nil {
+			return fmt.Errorf("Bad number: %s", val)
+		}
+		*t = f
+	case **float64:
+		val = strings.Replace(val, ",", ".", -1)
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			*t = nil
+		} else {
+			*t = &f
+		}
+	case **time.Time:
+		if val == "" {
+			*t = nil
+			return nil
+		}
+		d, err := ParseDate(val)
+		if err != nil && optional {
+			*t = nil
+		} else if err != nil {
+			return err
+		} else {
+			*t = &d
+		}
+	}
+	return nil
+}
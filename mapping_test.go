@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_DefaultMapping(t *testing.T) {
+	if err := DefaultMapping.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := DefaultMapping.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Mapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, DefaultMapping) {
+		t.Errorf("JSON round trip changed the mapping: %s", data)
+	}
+}
+
+func Test_Mapping_Validate(t *testing.T) {
+	field := func(name string, typ FieldType) FieldMapping {
+		return FieldMapping{Field: name, Column: Column{Column: name}, Type: typ}
+	}
+	tests := []struct {
+		Mapping Mapping
+		Err     string
+	}{
+		{
+			Mapping: Mapping{Key: []string{"ProbeNumber"}, Fields: []FieldMapping{field("ProbeNumber", StringField)}},
+		},
+		{
+			Mapping: Mapping{Key: []string{"ProbeNumber"}, Fields: []FieldMapping{field("Probe", StringField)}},
+			Err:     "unknown field: Probe",
+		},
+		{
+			Mapping: Mapping{Key: []string{"Age"}, Fields: []FieldMapping{field("Age", OptionalNumberField)}},
+			Err:     `type "optional-number" doesn't fit field Age`,
+		},
+		{
+			Mapping: Mapping{Key: []string{"ProbeNumber"}, Fields: []FieldMapping{field("Age", NumberField)}},
+			Err:     "key field not mapped: ProbeNumber",
+		},
+		{
+			Mapping: Mapping{Key: []string{"Age"}, Fields: []FieldMapping{field("Age", NumberField), field("Age", NumberField)}},
+			Err:     "field mapped twice: Age",
+		},
+	}
+	for i, test := range tests {
+		err := test.Mapping.Validate()
+		if test.Err == "" && err != nil {
+			t.Errorf("test %d: %s", i, err)
+		} else if test.Err != "" && (err == nil || err.Error() != test.Err) {
+			t.Errorf("test %d: want error %q, got %v", i, test.Err, err)
+		}
+	}
+}
+
+func Test_parseSubjects(t *testing.T) {
+	mapping := Mapping{
+		Skip: Column{Column: "nicht verwendbar"},
+		Key:  []string{"ProbeNumber"},
+		Fields: []FieldMapping{
+			{Field: "ProbeNumber", Column: Column{Column: "Probennummer"}, Type: StringField, Required: true},
+			{Field: "Age", Column: Column{Column: "Alter (PE)", Aliases: []string{"Alter"}}, Type: NumberField, Required: true},
+			{Field: "IgG", Column: Column{Column: "IgG"}, Type: StatusField, Required: true},
+			{Field: "EDSS", Column: Column{Column: "EDSS"}, Type: OptionalNumberField},
+		},
+	}
+	rows := [][]string{
+		{"Export"},
+		{"Probennummer", "nicht verwendbar", " Alter ", "IgG"},
+		{"1", "", "30,5", "positiv"},
+		{"2", "x", "kaputt", "positiv"},
+		{"", "", "", ""},
+		{"3", "", "41", "negativ"},
+	}
+	subjects, _, err := parseSubjects(rows, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subjects) != 2 {
+		t.Fatalf("want 2 subjects, got %d", len(subjects))
+	}
+	if s := subjects[0]; s.ProbeNumber != "1" || s.Age != 30.5 || !bool(s.IgG) || s.EDSS != nil {
+		t.Errorf("got %#v", s)
+	}
+	if s := subjects[1]; s.ProbeNumber != "3" || s.Age != 41 || bool(s.IgG) {
+		t.Errorf("got %#v", s)
+	}
+	mapping.Fields[2].Column.Column = "IgG Status"
+	if _, _, err := parseSubjects(rows, mapping); err == nil || !strings.Contains(err.Error(), "column IgG Status: Unknown column: IgG Status") {
+		t.Errorf("want unknown column error, got %v", err)
+	}
+}
+
+func Test_parseSubjects_badBirthday(t *testing.T) {
+	mapping := Mapping{
+		Key: []string{"ProbeNumber"},
+		Fields: []FieldMapping{
+			{Field: "ProbeNumber", Column: Column{Column: "Probennummer"}, Type: StringField, Required: true},
+			{Field: "Birthday", Column: Column{Column: "Geburtsdatum"}, Type: OptionalDateField, Required: true},
+		},
+	}
+	rows := [][]string{
+		{"Probennummer", "Geburtsdatum"},
+		{"1", "01.02.1980"},
+		{"2", "31.02.1980"},
+		{"3", ""},
+	}
+	subjects, warnings, err := parseSubjects(rows, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subjects) != 3 || subjects[0].Birthday == nil || subjects[1].Birthday != nil || subjects[2].Birthday != nil {
+		t.Fatalf("got %#v", subjects)
+	}
+	if len(warnings) != 1 || warnings[0].Line != 3 || warnings[0].Value != "31.02.1980" {
+		t.Errorf("want one warning for line 3, got %#v", warnings)
+	}
+	mapping.Fields[1].Type = DateField
+	if _, _, err := parseSubjects(rows, mapping); err == nil {
+		t.Errorf("want error for a bad DateField")
+	}
+}
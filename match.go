@@ -0,0 +1,578 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+func ParseMatchMethod(val string) (MatchMethod, error) {
+	for _, m := range MatchMethods {
+		if string(m) == val {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("Bad MatchMethod: %s", val)
+}
+
+// MatchMethod selects the algorithm used by match() to pair controls with
+// cases.
+type MatchMethod string
+
+const (
+	// Greedy walks the controls in input order and gives each of them the
+	// nearest case that is still available.
+	Greedy MatchMethod = "greedy"
+	// Optimal minimizes the total distance within each stratum.
+	Optimal MatchMethod = "optimal"
+	// CEM performs coarsened exact matching. All controls and cases that
+	// share a stratum and the same bins of the coarsened covariates form a
+	// matched set.
+	CEM MatchMethod = "cem"
+)
+
+// Distance returns how far apart two subjects are for matching purposes. It
+// returns false if the distance can't be computed, e.g. because a covariate
+// is missing.
+type Distance func(a, b *Subject) (float64, bool)
+
+// AgeDistance is the absolute age difference in years.
+func AgeDistance(a, b *Subject) (float64, bool) {
+	return math.Abs(a.Age - b.Age), true
+}
+
+// Score turns d into a score function for Subjects.Match. Closer subjects get
+// a higher score, subjects that can't be compared or are further apart than
+// caliper get a score of 0. A caliper of 0 disables the caliper.
+func (d Distance) Score(caliper float64) func(a, b *Subject) float64 {
+	return func(a, b *Subject) float64 {
+		dist, ok := d(a, b)
+		if !ok || (caliper > 0 && dist > caliper) {
+			return 0
+		}
+		return 1 / (1 + dist)
+	}
+}
+
+var MatchMethods = []MatchMethod{Greedy, Optimal, CEM}
+
+type MatchOptions struct {
+	Method MatchMethod
+	// Strata are the categories a control and its cases must agree on,
+	// e.g. Gender. Matching runs separately within each stratum.
+	Strata Strata
+	// Ratio is the number of cases matched to each control. Zero is treated
+	// as one.
+	Ratio int
+	// VariableRatio keeps controls that received at least one but fewer
+	// than Ratio cases. Otherwise such incomplete sets are dropped.
+	VariableRatio bool
+	// AgeCaliper is the maximum age difference in years between a control
+	// and its cases. Zero disables the caliper.
+	AgeCaliper float64
+	// SickDurationCaliper is the maximum difference in SickDuration. Zero
+	// disables the caliper, otherwise subjects without a SickDuration can't
+	// be matched.
+	SickDurationCaliper float64
+	// Distance is minimized by the matching algorithm. Defaults to
+	// AgeDistance.
+	Distance Distance
+	// Caliper is the maximum Distance between a control and its cases. Zero
+	// disables the caliper.
+	Caliper float64
+	// Coarsenings are the bins used by the CEM method. Ratio, calipers and
+	// Distance don't apply to CEM.
+	Coarsenings []Coarsening
+	// Seed makes the order in which controls and cases are considered, and
+	// therefore how ties are broken, independent of the input order. See
+	// Subjects.Shuffled. Zero keeps the input order.
+	Seed int64
+	// Replacement lets a case be matched to several controls. Each control
+	// then simply takes its nearest eligible cases, so Greedy and Optimal
+	// give the same result. The reuse shows up in the case weights.
+	Replacement bool
+}
+
+func (o MatchOptions) ratio() int {
+	if o.Ratio < 1 {
+		return 1
+	}
+	return o.Ratio
+}
+
+func (o MatchOptions) distance(a, b *Subject) (float64, bool) {
+	if o.Distance == nil {
+		return AgeDistance(a, b)
+	}
+	return o.Distance(a, b)
+}
+
+// reject returns the reason why a and b can't be matched, or "" if they can.
+func (o MatchOptions) reject(a, b *Subject) string {
+	if reason := o.Strata.reject(a, b); reason != "" {
+		return reason
+	}
+	if o.AgeCaliper > 0 && math.Abs(a.Age-b.Age) > o.AgeCaliper {
+		return fmt.Sprintf("age difference above %g years", o.AgeCaliper)
+	}
+	if o.SickDurationCaliper > 0 {
+		if a.SickDuration == nil || b.SickDuration == nil {
+			return "missing sick duration"
+		}
+		if math.Abs(*a.SickDuration-*b.SickDuration) > o.SickDurationCaliper {
+			return fmt.Sprintf("sick duration difference above %g", o.SickDurationCaliper)
+		}
+	}
+	d, ok := o.distance(a, b)
+	if !ok {
+		return "missing covariate"
+	}
+	if o.Caliper > 0 && d > o.Caliper {
+		return fmt.Sprintf("distance above %g", o.Caliper)
+	}
+	return ""
+}
+
+// MatchedSet is a group of subjects that were matched to each other. The
+// sets built by match() hold exactly one control, except for CEM.
+type MatchedSet struct {
+	Stratum  string
+	Controls []*Subject
+	Cases    []*Subject
+}
+
+// Subjects returns the controls of the set followed by its cases.
+func (m MatchedSet) Subjects() []*Subject {
+	return append(append([]*Subject{}, m.Controls...), m.Cases...)
+}
+
+// Pairs returns every combination of a control and a case within the set.
+func (m MatchedSet) Pairs() []Pair {
+	var pairs []Pair
+	for _, controlSubject := range m.Controls {
+		for _, caseSubject := range m.Cases {
+			pairs = append(pairs, Pair{Control: controlSubject, Case: caseSubject})
+		}
+	}
+	return pairs
+}
+
+type Pair struct {
+	Control *Subject
+	Case    *Subject
+}
+
+func (p Pair) AgeDiff() float64 {
+	return math.Abs(p.Case.Age - p.Control.Age)
+}
+
+type MatchedSets []MatchedSet
+
+// Subjects returns all subjects of all sets, set by set.
+func (m MatchedSets) Subjects() []*Subject {
+	var subjects []*Subject
+	for _, set := range m {
+		subjects = append(subjects, set.Subjects()...)
+	}
+	return subjects
+}
+
+func (m MatchedSets) Pairs() []Pair {
+	var pairs []Pair
+	for _, set := range m {
+		pairs = append(pairs, set.Pairs()...)
+	}
+	return pairs
+}
+
+// IndependentPairs returns the pairs of 1:1 sets in which no subject is
+// used twice. Otherwise the pairs are not independent, e.g. with ratio > 1,
+// CEM or replacement, and it returns an error.
+func (m MatchedSets) IndependentPairs() ([]Pair, error) {
+	seen := map[*Subject]bool{}
+	for i, set := range m {
+		if len(set.Controls) != 1 || len(set.Cases) != 1 {
+			return nil, fmt.Errorf("set %d has %d controls and %d cases, pair tests need 1:1 sets", i+1, len(set.Controls), len(set.Cases))
+		}
+		for _, s := range []*Subject{set.Controls[0], set.Cases[0]} {
+			if seen[s] {
+				return nil, fmt.Errorf("%s is in more than one set, pair tests need each subject only once", s)
+			}
+			seen[s] = true
+		}
+	}
+	return m.Pairs(), nil
+}
+
+// ControlWeights returns the weight of the controls in each set. Within a
+// set the controls share a weight proportional to the number of cases they
+// were matched to, and the weights are scaled so that they sum up to the
+// number of matched controls. For CEM these are the usual CEM weights, for
+// 1:1 matching every control has a weight of 1.
+func (m MatchedSets) ControlWeights() []float64 {
+	var (
+		weights  = make([]float64, len(m))
+		sum      float64
+		controls = map[*Subject]bool{}
+	)
+	for i, set := range m {
+		weights[i] = float64(len(set.Cases)) / float64(len(set.Controls))
+		sum += weights[i] * float64(len(set.Controls))
+		for _, s := range set.Controls {
+			controls[s] = true
+		}
+	}
+	for i := range weights {
+		weights[i] *= float64(len(controls)) / sum
+	}
+	return weights
+}
+
+// Unique returns every matched subject once, in the order of its first
+// appearance. Use it together with Weights, as Subjects lists a case that was
+// matched with replacement once per set.
+func (m MatchedSets) Unique() []*Subject {
+	var (
+		subjects []*Subject
+		seen     = map[*Subject]bool{}
+	)
+	for _, s := range m.Subjects() {
+		if !seen[s] {
+			seen[s] = true
+			subjects = append(subjects, s)
+		}
+	}
+	return subjects
+}
+
+// Weights returns the weight of every matched subject. A case has a weight
+// of 1 for every set it belongs to, controls get their ControlWeights.
+func (m MatchedSets) Weights() Weights {
+	weights := Weights{}
+	controlWeights := m.ControlWeights()
+	for i, set := range m {
+		for _, s := range set.Controls {
+			weights[s] += controlWeights[i]
+		}
+		for _, s := range set.Cases {
+			weights[s]++
+		}
+	}
+	return weights
+}
+
+// AgeDiffs returns the age difference of every control/case pair. The pairs
+// of a set share the weight of its control, so every matched control counts
+// as much as in the other weighted outputs.
+func (m MatchedSets) AgeDiffs() Histogram {
+	var h Histogram
+	controlWeights := m.ControlWeights()
+	for i, set := range m {
+		for _, pair := range set.Pairs() {
+			h.Add(pair.AgeDiff(), controlWeights[i]/float64(len(set.Cases)))
+		}
+	}
+	return h
+}
+
+// Weights are frequency weights of subjects. A nil Weights gives every
+// subject a weight of 1.
+type Weights map[*Subject]float64
+
+// Of returns the weight of s.
+func (w Weights) Of(s *Subject) float64 {
+	if w == nil {
+		return 1
+	}
+	return w[s]
+}
+
+// Unmatched is a subject that did not end up in any matched set.
+type Unmatched struct {
+	Subject *Subject
+	Reason  string
+	// Control is set for a case that was dropped together with the
+	// incomplete set of this control. It is only named when the reason is
+	// written, so that a stripped control shows its pseudonym.
+	Control *Subject
+}
+
+// Explanation returns the reason, naming Control if it is set.
+func (u Unmatched) Explanation() string {
+	if u.Control == nil {
+		return u.Reason
+	}
+	return fmt.Sprintf("control %s %s", u.Control, u.Reason)
+}
+
+// match assigns up to opts.Ratio nearby cases from the same stratum to each
+// control. The returned sets are in the input order of the controls, even if
+// opts.Seed shuffles the order in which they are matched. All controls and
+// cases that are not part of a set are returned as unmatched, along with the
+// reason why.
+func match(subjects []*Subject, opts MatchOptions) (MatchedSets, []Unmatched) {
+	var (
+		controls []*Subject
+		cases    []*Subject
+	)
+	for _, subject := range subjects {
+		if subject.Diagnosis == GK {
+			controls = append(controls, subject)
+		} else {
+			cases = append(cases, subject)
+		}
+	}
+	if opts.Method == CEM {
+		return matchCEM(controls, cases, opts)
+	}
+	var (
+		partners         map[*Subject][]*Subject
+		shuffledControls = Subjects(controls).Shuffled(opts.Seed)
+		shuffledCases    = Subjects(cases).Shuffled(opts.Seed)
+	)
+	switch {
+	case opts.Replacement:
+		partners = matchWithReplacement(shuffledControls, shuffledCases, opts)
+	case opts.Method == Greedy:
+		partners = matchGreedy(shuffledControls, shuffledCases, opts)
+	case opts.Method == Optimal:
+		partners = matchOptimal(shuffledControls, shuffledCases, opts)
+	default:
+		panic(fmt.Errorf("bug: unknown match method: %s", opts.Method))
+	}
+	var (
+		sets      MatchedSets
+		unmatched []Unmatched
+		dropped   = map[*Subject]Unmatched{}
+	)
+	for _, controlSubject := range controls {
+		matchedCases := partners[controlSubject]
+		if len(matchedCases) == 0 {
+			reason := unmatchedReason(controlSubject, cases, "case", opts)
+			unmatched = append(unmatched, Unmatched{Subject: controlSubject, Reason: reason})
+			continue
+		}
+		if len(matchedCases) < opts.ratio() && !opts.VariableRatio {
+			reason := fmt.Sprintf("only %d of %d cases available", len(matchedCases), opts.ratio())
+			unmatched = append(unmatched, Unmatched{Subject: controlSubject, Reason: reason})
+			for _, caseSubject := range matchedCases {
+				dropped[caseSubject] = Unmatched{
+					Subject: caseSubject,
+					Reason:  fmt.Sprintf("had only %d of %d cases", len(matchedCases), opts.ratio()),
+					Control: controlSubject,
+				}
+			}
+			continue
+		}
+		sets = append(sets, MatchedSet{
+			Stratum:  opts.Strata.Key(controlSubject),
+			Controls: []*Subject{controlSubject},
+			Cases:    matchedCases,
+		})
+	}
+	matchedCases := map[*Subject]bool{}
+	for _, set := range sets {
+		for _, caseSubject := range set.Cases {
+			matchedCases[caseSubject] = true
+		}
+	}
+	for _, caseSubject := range cases {
+		if matchedCases[caseSubject] {
+			continue
+		}
+		u, ok := dropped[caseSubject]
+		if !ok {
+			u = Unmatched{Subject: caseSubject, Reason: unmatchedReason(caseSubject, controls, "control", opts)}
+		}
+		unmatched = append(unmatched, u)
+	}
+	return sets, unmatched
+}
+
+// unmatchedReason explains why s was not matched to any of the given
+// candidates, which are described by kind.
+func unmatchedReason(s *Subject, candidates []*Subject, kind string, opts MatchOptions) string {
+	var reasons []string
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		reason := opts.reject(s, candidate)
+		if reason == "" {
+			return fmt.Sprintf("all eligible %ss were matched with others", kind)
+		}
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("no %ss", kind)
+	}
+	return fmt.Sprintf("no eligible %s: %s", kind, strings.Join(reasons, ", "))
+}
+
+// matchGreedy hands out cases in ratio rounds. In every round each control,
+// in input order, takes the nearest case of its stratum that is still
+// available.
+func matchGreedy(controls, cases []*Subject, opts MatchOptions) map[*Subject][]*Subject {
+	partners := map[*Subject][]*Subject{}
+	cases = append([]*Subject{}, cases...)
+	for round := 0; round < opts.ratio(); round++ {
+		for _, controlSubject := range controls {
+			bestMatch := -1
+			for i, caseSubject := range cases {
+				if opts.reject(controlSubject, caseSubject) != "" {
+					continue
+				}
+				if bestMatch == -1 {
+					bestMatch = i
+					continue
+				}
+				currentDistance, _ := opts.distance(controlSubject, caseSubject)
+				bestDistance, _ := opts.distance(controlSubject, cases[bestMatch])
+				if currentDistance < bestDistance {
+					bestMatch = i
+				}
+			}
+			if bestMatch > -1 {
+				partners[controlSubject] = append(partners[controlSubject], cases[bestMatch])
+				cases = append(cases[0:bestMatch], cases[bestMatch+1:]...)
+			}
+		}
+	}
+	return partners
+}
+
+// matchWithReplacement gives each control its ratio nearest eligible cases,
+// regardless of which cases the other controls took. Ties go to the case
+// that comes first.
+func matchWithReplacement(controls, cases []*Subject, opts MatchOptions) map[*Subject][]*Subject {
+	partners := map[*Subject][]*Subject{}
+	for _, controlSubject := range controls {
+		var (
+			eligible  []*Subject
+			distances = map[*Subject]float64{}
+		)
+		for _, caseSubject := range cases {
+			if opts.reject(controlSubject, caseSubject) != "" {
+				continue
+			}
+			eligible = append(eligible, caseSubject)
+			distances[caseSubject], _ = opts.distance(controlSubject, caseSubject)
+		}
+		sort.SliceStable(eligible, func(i, j int) bool {
+			return distances[eligible[i]] < distances[eligible[j]]
+		})
+		if len(eligible) > opts.ratio() {
+			eligible = eligible[:opts.ratio()]
+		}
+		if len(eligible) > 0 {
+			partners[controlSubject] = eligible
+		}
+	}
+	return partners
+}
+
+// matchOptimal solves one assignment problem per stratum so that the sum of
+// the distances between controls and their cases is minimal. Each
+// control takes part in the assignment with ratio rows, so it can receive up
+// to ratio cases. Pairs rejected by a caliper get a cost that is higher than
+// any valid assignment, so they are only chosen if nothing else is left, and
+// are then discarded.
+//
+// If there are not enough cases to fill every set and incomplete sets are
+// dropped, the cheapest assignment tends to spread the cases thinly over the
+// controls. Then controls earlier in the input order are filled first, as
+// with matchGreedy, and the stratum is solved again without the controls
+// that still ended up with an incomplete set.
+func matchOptimal(controls, cases []*Subject, opts MatchOptions) map[*Subject][]*Subject {
+	var (
+		partners       = map[*Subject][]*Subject{}
+		keys           []string
+		strataControls = map[string][]*Subject{}
+		strataCases    = map[string][]*Subject{}
+	)
+	for _, s := range controls {
+		key := opts.Strata.Key(s)
+		if strataControls[key] == nil {
+			keys = append(keys, key)
+		}
+		strataControls[key] = append(strataControls[key], s)
+	}
+	for _, s := range cases {
+		key := opts.Strata.Key(s)
+		strataCases[key] = append(strataCases[key], s)
+	}
+	complete := opts.ratio() > 1 && !opts.VariableRatio
+	for _, key := range keys {
+		stratumControls, stratumCases := strataControls[key], strataCases[key]
+		for len(stratumControls) > 0 && len(stratumCases) > 0 {
+			stratumPartners := assignOptimal(stratumControls, stratumCases, opts, complete)
+			var kept []*Subject
+			for _, controlSubject := range stratumControls {
+				if n := len(stratumPartners[controlSubject]); !complete || n == 0 || n == opts.ratio() {
+					kept = append(kept, controlSubject)
+				}
+			}
+			if len(kept) == len(stratumControls) {
+				for controlSubject, p := range stratumPartners {
+					partners[controlSubject] = p
+				}
+				break
+			}
+			stratumControls = kept
+		}
+	}
+	return partners
+}
+
+// assignOptimal solves the assignment problem of one stratum for
+// matchOptimal. With priority, the rows of each control cost more than
+// those of the control before it, by more than all distances together.
+func assignOptimal(controls, cases []*Subject, opts MatchOptions, priority bool) map[*Subject][]*Subject {
+	var (
+		rows      []*Subject
+		cost      [][]float64
+		rejected  [][]bool
+		forbidden = 1.0
+	)
+	for _, s := range controls {
+		for i := 0; i < opts.ratio(); i++ {
+			rows = append(rows, s)
+		}
+	}
+	for i, controlSubject := range rows {
+		cost = append(cost, make([]float64, len(cases)))
+		rejected = append(rejected, make([]bool, len(cases)))
+		for j, caseSubject := range cases {
+			if opts.reject(controlSubject, caseSubject) != "" {
+				rejected[i][j] = true
+				continue
+			}
+			cost[i][j], _ = opts.distance(controlSubject, caseSubject)
+			forbidden += cost[i][j]
+		}
+	}
+	step := 0.0
+	if priority {
+		step = forbidden
+		forbidden *= float64(len(controls) + 1)
+	}
+	for i := range cost {
+		for j := range cost[i] {
+			if rejected[i][j] {
+				cost[i][j] = forbidden
+			} else {
+				cost[i][j] += float64(i/opts.ratio()) * step
+			}
+		}
+	}
+	partners := map[*Subject][]*Subject{}
+	for i, j := range hungarian(cost) {
+		if j > -1 && !rejected[i][j] {
+			controlSubject := rows[i]
+			partners[controlSubject] = append(partners[controlSubject], cases[j])
+		}
+	}
+	return partners
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testSubjects(diagnosis Diagnosis, gender Gender, ages ...float64) []*Subject {
+	var subjects []*Subject
+	for _, age := range ages {
+		subjects = append(subjects, &Subject{Diagnosis: diagnosis, Gender: gender, Age: age})
+	}
+	return subjects
+}
+
+func Test_match(t *testing.T) {
+	controls := testSubjects(GK, Female, 30, 40)
+	cases := append(testSubjects(RRMS, Female, 34, 41, 60, 29), testSubjects(RRMS, Male, 40)...)
+	subjects := append(append([]*Subject{}, controls...), cases...)
+	gender, _ := CategoryByName("Gender")
+	strata := Strata{gender}
+	tests := []struct {
+		Opts MatchOptions
+		Want [][]*Subject
+	}{
+		{
+			Opts: MatchOptions{Method: Greedy, Strata: strata},
+			Want: [][]*Subject{{cases[3]}, {cases[1]}},
+		},
+		{
+			Opts: MatchOptions{Method: Optimal, Strata: strata},
+			Want: [][]*Subject{{cases[3]}, {cases[1]}},
+		},
+		{
+			Opts: MatchOptions{Method: Greedy, Strata: strata, Ratio: 2},
+			Want: [][]*Subject{{cases[3], cases[0]}, {cases[1], cases[2]}},
+		},
+		{
+			Opts: MatchOptions{Method: Optimal, Strata: strata, Ratio: 2},
+			Want: [][]*Subject{{cases[0], cases[3]}, {cases[1], cases[2]}},
+		},
+		{
+			// Four cases can't fill both sets, so only the first control
+			// keeps its three nearest.
+			Opts: MatchOptions{Method: Optimal, Strata: strata, Ratio: 3},
+			Want: [][]*Subject{{cases[3], cases[0], cases[1]}},
+		},
+		{
+			Opts: MatchOptions{Method: Optimal, Strata: strata, Ratio: 3, VariableRatio: true},
+			Want: [][]*Subject{{cases[0], cases[3]}, {cases[1], cases[2]}},
+		},
+		{
+			Opts: MatchOptions{Method: Greedy, Strata: strata, Ratio: 2, VariableRatio: true, AgeCaliper: 5},
+			Want: [][]*Subject{{cases[3], cases[0]}, {cases[1]}},
+		},
+		{
+			Opts: MatchOptions{Method: Optimal, Strata: strata, Ratio: 2, VariableRatio: true, AgeCaliper: 5},
+			Want: [][]*Subject{{cases[0], cases[3]}, {cases[1]}},
+		},
+		{
+			Opts: MatchOptions{Method: Optimal},
+			Want: [][]*Subject{{cases[3]}, {cases[4]}},
+		},
+	}
+	for i, test := range tests {
+		sets, unmatched := match(subjects, test.Opts)
+		if got, want := len(sets.Unique())+len(unmatched), len(subjects); got != want {
+			t.Errorf("test %d: want %d matched and unmatched subjects, got %d", i, want, got)
+		}
+		if len(sets) != len(test.Want) {
+			t.Errorf("test %d: want %d sets, got %d", i, len(test.Want), len(sets))
+			continue
+		}
+		for j, set := range sets {
+			if len(set.Controls) != 1 || set.Controls[0] != controls[j] {
+				t.Errorf("test %d: set %d: want control %d, got %v", i, j, j, set.Controls)
+			}
+			if !sameSubjects(set.Cases, test.Want[j]) {
+				t.Errorf("test %d: set %d: want cases %v, got %v", i, j, test.Want[j], set.Cases)
+			}
+		}
+	}
+}
+
+func Test_match_completeSets(t *testing.T) {
+	// The cheapest assignment gives the control aged 15 both cases at 13
+	// and 17, leaving one case each to the other controls, so only one set
+	// would be complete. Greedy fills the sets of the first two controls.
+	controls := testSubjects(GK, Female, 10, 20, 15)
+	cases := testSubjects(RRMS, Female, 13, 17, 6, 24)
+	subjects := append(append([]*Subject{}, controls...), cases...)
+	want := [][]*Subject{{cases[0], cases[2]}, {cases[1], cases[3]}}
+	for _, method := range []MatchMethod{Greedy, Optimal} {
+		sets, _ := match(subjects, MatchOptions{Method: method, Ratio: 2, AgeCaliper: 5})
+		if len(sets) != len(want) {
+			t.Errorf("%s: want %d sets, got %d", method, len(want), len(sets))
+			continue
+		}
+		for i, set := range sets {
+			if set.Controls[0] != controls[i] || !sameSubjects(set.Cases, want[i]) {
+				t.Errorf("%s: set %d: got %v with %v", method, i, set.Controls, set.Cases)
+			}
+		}
+	}
+}
+
+func Test_match_Replacement(t *testing.T) {
+	controls := testSubjects(GK, Female, 30, 31, 45)
+	cases := testSubjects(RRMS, Female, 30, 50, 44)
+	subjects := append(append([]*Subject{}, controls...), cases...)
+	for _, method := range []MatchMethod{Greedy, Optimal} {
+		sets, unmatched := match(subjects, MatchOptions{Method: method, Replacement: true})
+		if len(sets) != 3 {
+			t.Fatalf("%s: want 3 sets, got %d", method, len(sets))
+		}
+		for i, want := range []*Subject{cases[0], cases[0], cases[2]} {
+			if !sameSubjects(sets[i].Cases, []*Subject{want}) {
+				t.Errorf("%s: set %d: want case %v, got %v", method, i, want, sets[i].Cases)
+			}
+		}
+		if len(unmatched) != 1 || unmatched[0].Subject != cases[1] {
+			t.Errorf("%s: want case 50 unmatched, got %v", method, unmatched)
+		}
+		weights := sets.Weights()
+		if weights[cases[0]] != 2 || weights[cases[2]] != 1 || weights[controls[0]] != 1 {
+			t.Errorf("%s: got weights %v", method, weights)
+		}
+		h := sets.AgeDiffs()
+		if h.Min() != 0 || h.Max() != 1 || h.Median() != 1 {
+			t.Errorf("%s: got age differences %s", method, h)
+		}
+	}
+}
+
+func Test_Histogram(t *testing.T) {
+	tests := []struct {
+		Values  []float64
+		Weights []float64
+		Min     float64
+		Median  float64
+		Mean    float64
+	}{
+		{Values: []float64{3, 1, 2}, Min: 1, Median: 2, Mean: 2},
+		{Values: []float64{4, 1, 3, 2}, Min: 1, Median: 2.5, Mean: 2.5},
+		{Values: []float64{4, 1, 3, 2}, Weights: []float64{1, 1, 2, 0}, Min: 1, Median: 3, Mean: 2.75},
+		{Values: []float64{5, 1}, Weights: []float64{0.5, 0.5}, Min: 1, Median: 3, Mean: 3},
+	}
+	for i, test := range tests {
+		h := Histogram{Values: test.Values, Weights: test.Weights}
+		if h.Min() != test.Min || h.Median() != test.Median || h.Mean() != test.Mean {
+			t.Errorf("test %d: want min %f median %f mean %f, got %s", i, test.Min, test.Median, test.Mean, h)
+		}
+	}
+}
+
+// sameSubjects returns true if a and b contain the same subjects,
+// regardless of their order.
+func sameSubjects(a, b []*Subject) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[*Subject]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		count[s]--
+	}
+	for _, c := range count {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_matchCEM(t *testing.T) {
+	age, _ := CovariateByName("Age")
+	controls := testSubjects(GK, Female, 31, 33, 36, 50)
+	cases := testSubjects(RRMS, Female, 30, 38, 39, 42)
+	subjects := append(append([]*Subject{}, controls...), cases...)
+	sets, unmatched := match(subjects, MatchOptions{
+		Method:      CEM,
+		Coarsenings: []Coarsening{{Covariate: age, Width: 5}},
+	})
+	if len(sets) != 2 {
+		t.Fatalf("want 2 sets, got %d", len(sets))
+	}
+	if !sameSubjects(sets[0].Subjects(), []*Subject{controls[0], controls[1], cases[0]}) {
+		t.Errorf("set 0: got %v", sets[0].Subjects())
+	}
+	if !sameSubjects(sets[1].Subjects(), []*Subject{controls[2], cases[1], cases[2]}) {
+		t.Errorf("set 1: got %v", sets[1].Subjects())
+	}
+	if len(unmatched) != 2 {
+		t.Errorf("want 2 unmatched, got %d", len(unmatched))
+	}
+	// 3 matched controls and 3 matched cases: the controls of the first set
+	// get 1/2 * 3/3, the one of the second set 2/1 * 3/3.
+	weights := sets.Weights()
+	want := map[*Subject]float64{
+		controls[0]: 0.5,
+		controls[1]: 0.5,
+		controls[2]: 2,
+		cases[0]:    1,
+		cases[1]:    1,
+		cases[2]:    1,
+	}
+	for s, w := range want {
+		if weights[s] != w {
+			t.Errorf("%v: want weight %f, got %f", s, w, weights[s])
+		}
+	}
+}
+
+func Test_ParseCoarsenings(t *testing.T) {
+	tests := []struct {
+		Val  string
+		Want string
+		Err  string
+	}{
+		{Val: "Age=5", Want: "Age=5"},
+		{Val: "Age=5,SickDuration=12", Want: "Age=5 SickDuration=12"},
+		{Val: "Age:5, SickDuration:6", Want: "Age=5 SickDuration=6"},
+		{Val: " Age = 2.5 ,", Want: "Age=2.5"},
+		{Val: "Age", Err: "Bad coarsening: Age"},
+		{Val: "Alter=5", Err: "Unknown covariate: Alter"},
+		{Val: "Age=0", Err: "Bad coarsening width: Age=0"},
+	}
+	for i, test := range tests {
+		coarsenings, err := ParseCoarsenings(test.Val)
+		if test.Err != "" {
+			if err == nil || err.Error() != test.Err {
+				t.Errorf("test %d: want error %q, got %v", i, test.Err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("test %d: %s", i, err)
+			continue
+		}
+		var got []string
+		for _, c := range coarsenings {
+			got = append(got, fmt.Sprintf("%s=%g", c.Covariate.Name, c.Width))
+		}
+		if strings.Join(got, " ") != test.Want {
+			t.Errorf("test %d: want %s, got %v", i, test.Want, got)
+		}
+	}
+}
+
+func Test_match_Seed(t *testing.T) {
+	// Every case is exactly as far away from every control, so the result
+	// only depends on how ties are broken.
+	controls := testSubjects(GK, Female, 40, 40, 40)
+	cases := testSubjects(RRMS, Female, 38, 42, 38, 42)
+	subjects := append(append([]*Subject{}, controls...), cases...)
+	for i, s := range subjects {
+		s.ProbeNumber = fmt.Sprintf("%02d", i)
+	}
+	reversed := make([]*Subject, len(subjects))
+	for i, s := range subjects {
+		reversed[len(subjects)-1-i] = s
+	}
+	for _, method := range []MatchMethod{Greedy, Optimal} {
+		opts := MatchOptions{Method: method, Seed: 42}
+		a, _ := match(subjects, opts)
+		b, _ := match(reversed, opts)
+		pairs := map[Pair]bool{}
+		for _, pair := range a.Pairs() {
+			pairs[pair] = true
+		}
+		for _, pair := range b.Pairs() {
+			if !pairs[pair] {
+				t.Errorf("%s: pair %v depends on the input order", method, pair)
+			}
+		}
+	}
+}
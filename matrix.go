@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+var errSingular = errors.New("matrix is singular")
+
+// invert returns the inverse of the square matrix a using Gauss-Jordan
+// elimination with partial pivoting. a is not modified.
+func invert(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = make([]float64, 2*n)
+		copy(m[i], a[i])
+		m[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, errSingular
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		div := m[col][col]
+		for j := range m[col] {
+			m[col][j] /= div
+		}
+		for row := 0; row < n; row++ {
+			if row == col || m[row][col] == 0 {
+				continue
+			}
+			factor := m[row][col]
+			for j := range m[row] {
+				m[row][j] -= factor * m[col][j]
+			}
+		}
+	}
+	inv := make([][]float64, n)
+	for i := range m {
+		inv[i] = m[i][n:]
+	}
+	return inv, nil
+}
+
+// mulVec returns the product of the matrix a and the vector v.
+func mulVec(a [][]float64, v []float64) []float64 {
+	r := make([]float64, len(a))
+	for i := range a {
+		for j := range v {
+			r[i] += a[i][j] * v[j]
+		}
+	}
+	return r
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func newMatrix(rows, cols int) [][]float64 {
+	m := make([][]float64, rows)
+	for i := range m {
+		m[i] = make([]float64, cols)
+	}
+	return m
+}
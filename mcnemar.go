@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// McNemar counts matched control/case pairs by whether each side has a
+// binary exposure. Pairs where either side has no value are left out.
+type McNemar struct {
+	Both        int
+	ControlOnly int
+	CaseOnly    int
+	Neither     int
+}
+
+// Exposure is a binary covariate together with the value, 0 or 1, that
+// counts as exposed.
+type Exposure struct {
+	Covariate Covariate
+	Exposed   float64
+}
+
+// ParseExposures parses a comma separated list of binary covariate names.
+// A name may be followed by =0 to count the subjects coded 0 as exposed,
+// e.g. IgG=0 for IgG negative, otherwise those coded 1 are.
+func ParseExposures(val string) ([]Exposure, error) {
+	var exposures []Exposure
+	for _, entry := range strings.Split(val, ",") {
+		name, level := strings.TrimSpace(entry), "1"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, level = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+		}
+		c, err := CovariateByName(name)
+		if err != nil {
+			return nil, err
+		}
+		e := Exposure{Covariate: c, Exposed: 1}
+		switch level {
+		case "1":
+		case "0":
+			e.Exposed = 0
+		default:
+			return nil, fmt.Errorf("Bad exposed level: %s", entry)
+		}
+		exposures = append(exposures, e)
+	}
+	return exposures, nil
+}
+
+// NewMcNemar counts pairs by exposure, whose covariate must be coded as 0
+// and 1.
+func NewMcNemar(pairs []Pair, exposure Exposure) (McNemar, error) {
+	var m McNemar
+	for _, pair := range pairs {
+		controlValue, ok1 := exposure.Covariate.Value(pair.Control)
+		caseValue, ok2 := exposure.Covariate.Value(pair.Case)
+		if !ok1 || !ok2 {
+			continue
+		}
+		for _, v := range []float64{controlValue, caseValue} {
+			if v != 0 && v != 1 {
+				return McNemar{}, fmt.Errorf("%s is not binary: %s", exposure.Covariate.Name, numberStr(v))
+			}
+		}
+		switch controlExposed, caseExposed := controlValue == exposure.Exposed, caseValue == exposure.Exposed; {
+		case controlExposed && caseExposed:
+			m.Both++
+		case controlExposed:
+			m.ControlOnly++
+		case caseExposed:
+			m.CaseOnly++
+		default:
+			m.Neither++
+		}
+	}
+	return m, nil
+}
+
+// ChiSquare returns McNemar's statistic and its p-value from the chi-square
+// distribution with 1 degree of freedom. With corrected it applies Edwards'
+// continuity correction. Both are NaN if there are no discordant pairs.
+func (m McNemar) ChiSquare(corrected bool) (chi2, p float64) {
+	n := float64(m.ControlOnly + m.CaseOnly)
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	diff := math.Abs(float64(m.CaseOnly - m.ControlOnly))
+	if corrected {
+		diff = math.Max(diff-1, 0)
+	}
+	chi2 = diff * diff / n
+	return chi2, normalPValue(math.Sqrt(chi2))
+}
+
+// ExactP returns the two-sided p-value of the exact binomial test of the
+// discordant pairs, i.e. twice the smaller tail of Binomial(n, 1/2).
+func (m McNemar) ExactP() float64 {
+	n := m.ControlOnly + m.CaseOnly
+	if n == 0 {
+		return math.NaN()
+	}
+	k := m.ControlOnly
+	if m.CaseOnly < k {
+		k = m.CaseOnly
+	}
+	var tail float64
+	for i := 0; i <= k; i++ {
+		tail += math.Exp(logBinomial(n, i) - float64(n)*math.Ln2)
+	}
+	return math.Min(1, 2*tail)
+}
+
+// logBinomial returns the logarithm of n choose k.
+func logBinomial(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// OddsRatio returns the matched-pair odds ratio of exposure for cases, the
+// pairs where only the case is exposed over those where only the control
+// is, with its 95% Wald confidence interval on the log scale. The interval
+// is NaN if either discordant count is 0.
+func (m McNemar) OddsRatio() (or, lower, upper float64) {
+	b, c := float64(m.ControlOnly), float64(m.CaseOnly)
+	or = c / b
+	if b == 0 || c == 0 {
+		return or, math.NaN(), math.NaN()
+	}
+	se := math.Sqrt(1/b + 1/c)
+	return or, math.Exp(math.Log(or) - z95*se), math.Exp(math.Log(or) + z95*se)
+}
+
+// z95 is the 97.5% quantile of the standard normal distribution.
+const z95 = 1.959963984540054
+
+// WriteMcNemar writes the four pair counts and, in the columns next to them,
+// the test statistics.
+func WriteMcNemar(w *csv.Writer, m McNemar) error {
+	header := []string{"Control Risk Factor", "Case Risk Factor", "Count", "Statistik", "Wert"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	chi2, p := m.ChiSquare(false)
+	chi2Corrected, pCorrected := m.ChiSquare(true)
+	or, lower, upper := m.OddsRatio()
+	stats := []struct {
+		Name  string
+		Value float64
+	}{
+		{"Chi-Quadrat", chi2},
+		{"p", p},
+		{"Chi-Quadrat (stetigkeitskorrigiert)", chi2Corrected},
+		{"p (stetigkeitskorrigiert)", pCorrected},
+		{"p (exakt, binomial)", m.ExactP()},
+		{"Odds Ratio", or},
+		{"Odds Ratio 95% KI unten", lower},
+		{"Odds Ratio 95% KI oben", upper},
+	}
+	counts := []struct {
+		ControlRisk bool
+		CaseRisk    bool
+		Count       int
+	}{
+		{false, true, m.CaseOnly},
+		{true, false, m.ControlOnly},
+		{true, true, m.Both},
+		{false, false, m.Neither},
+	}
+	for i, stat := range stats {
+		row := make([]string, len(header))
+		if i < len(counts) {
+			row[0] = yesNo(counts[i].ControlRisk)
+			row[1] = yesNo(counts[i].CaseRisk)
+			row[2] = fmt.Sprintf("%d", counts[i].Count)
+		}
+		row[3] = stat.Name
+		row[4] = strconv.FormatFloat(stat.Value, 'f', -1, 64)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_NewMcNemar(t *testing.T) {
+	pos, neg := &Subject{IgG: true}, &Subject{IgG: false}
+	pairs := []Pair{
+		{Control: pos, Case: pos},
+		{Control: pos, Case: neg},
+		{Control: neg, Case: pos},
+		{Control: neg, Case: pos},
+		{Control: neg, Case: neg},
+	}
+	m, err := NewMcNemar(pairs, Exposure{mustCovariate("IgG"), 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (McNemar{Both: 1, ControlOnly: 1, CaseOnly: 2, Neither: 1}); m != want {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+	m, err = NewMcNemar(pairs, Exposure{mustCovariate("IgG"), 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (McNemar{Both: 1, ControlOnly: 2, CaseOnly: 1, Neither: 1}); m != want {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+	age := Covariate{"Age", func(s *Subject) (float64, bool) { return 42, true }}
+	if _, err := NewMcNemar(pairs, Exposure{age, 1}); err == nil {
+		t.Errorf("want error for non-binary exposure")
+	}
+}
+
+func Test_McNemar(t *testing.T) {
+	tests := []struct {
+		McNemar          McNemar
+		Chi2, P          float64
+		Chi2Corrected    float64
+		PCorrected       float64
+		ExactP           float64
+		OR, Lower, Upper float64
+	}{
+		{
+			McNemar:       McNemar{Both: 10, ControlOnly: 5, CaseOnly: 15, Neither: 20},
+			Chi2:          5,
+			P:             0.025347,
+			Chi2Corrected: 4.05,
+			PCorrected:    0.044171,
+			ExactP:        0.041389,
+			OR:            3,
+			Lower:         1.090342,
+			Upper:         8.254292,
+		},
+		{
+			McNemar:       McNemar{Both: 3, ControlOnly: 2, CaseOnly: 2, Neither: 3},
+			Chi2:          0,
+			P:             1,
+			Chi2Corrected: 0,
+			PCorrected:    1,
+			ExactP:        1,
+			OR:            1,
+			Lower:         0.140863,
+			Upper:         7.099071,
+		},
+	}
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-5
+	}
+	for i, test := range tests {
+		m := test.McNemar
+		chi2, p := m.ChiSquare(false)
+		if !near(chi2, test.Chi2) || !near(p, test.P) {
+			t.Errorf("test %d: got chi2=%f p=%f", i, chi2, p)
+		}
+		chi2, p = m.ChiSquare(true)
+		if !near(chi2, test.Chi2Corrected) || !near(p, test.PCorrected) {
+			t.Errorf("test %d: got corrected chi2=%f p=%f", i, chi2, p)
+		}
+		if got := m.ExactP(); !near(got, test.ExactP) {
+			t.Errorf("test %d: got exact p=%f", i, got)
+		}
+		or, lower, upper := m.OddsRatio()
+		if !near(or, test.OR) || !near(lower, test.Lower) || !near(upper, test.Upper) {
+			t.Errorf("test %d: got OR=%f (%f, %f)", i, or, lower, upper)
+		}
+	}
+	_, lower, _ := McNemar{ControlOnly: 0, CaseOnly: 4}.OddsRatio()
+	if !math.IsNaN(lower) {
+		t.Errorf("want NaN interval without discordant controls, got %f", lower)
+	}
+}
+
+func Test_ParseExposures(t *testing.T) {
+	got, err := ParseExposures("Nikotinabusus, IgG=0,IgM=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		Name    string
+		Exposed float64
+	}{{"Nikotinabusus", 1}, {"IgG", 0}, {"IgM", 1}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d exposures", len(got))
+	}
+	for i, w := range want {
+		if got[i].Covariate.Name != w.Name || got[i].Exposed != w.Exposed {
+			t.Errorf("test %d: got %s=%f", i, got[i].Covariate.Name, got[i].Exposed)
+		}
+	}
+	for _, val := range []string{"IgG=2", "IgG=negativ", "Foo"} {
+		if _, err := ParseExposures(val); err == nil {
+			t.Errorf("want error for %s", val)
+		}
+	}
+}
+
+// Test_IgG_McNemar pins the counts of the IgG table to the original
+// orientation, which took IgG negative as the risk factor.
+func Test_IgG_McNemar(t *testing.T) {
+	var sets MatchedSets
+	for _, p := range []struct {
+		Control, Case Status
+		Count         int
+	}{{true, false, 3}, {false, true, 2}, {false, false, 5}, {true, true, 4}} {
+		for i := 0; i < p.Count; i++ {
+			sets = append(sets, MatchedSet{Controls: []*Subject{{IgG: p.Control}}, Cases: []*Subject{{IgG: p.Case}}})
+		}
+	}
+	report := DefaultReports().Lookup("IgG-MS-GK-Matched-Mc-Nemar")
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := report.Write(w, &Inputs{Matched: sets}); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	lines := strings.Split(buf.String(), "\n")
+	want := []string{"no,yes,3,", "yes,no,2,", "yes,yes,5,", "no,no,4,"}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Errorf("line %d: want %s..., got %s", i+1, prefix, lines[i+1])
+		}
+	}
+	reg := DefaultReports()
+	if err := reg.RegisterMcNemar([]Exposure{{mustCovariate("IgG"), 1}}); err == nil {
+		t.Errorf("want error for IgG with the other exposed level")
+	}
+	if err := reg.RegisterMcNemar([]Exposure{{mustCovariate("IgG"), 0}}); err != nil {
+		t.Errorf("got %s for IgG with the same exposed level", err)
+	}
+}
+
+func Test_McNemar_notIndependent(t *testing.T) {
+	controls := testSubjects(GK, Female, 30, 40)
+	cases := testSubjects(RRMS, Female, 29, 31, 39, 41)
+	subjects := append(append([]*Subject{}, controls...), cases...)
+	report := DefaultReports().Lookup("IgG-MS-GK-Matched-Mc-Nemar")
+	tests := []struct {
+		Opts    MatchOptions
+		WantErr bool
+	}{
+		{Opts: MatchOptions{Method: Greedy}},
+		{Opts: MatchOptions{Method: Greedy, Ratio: 2}, WantErr: true},
+		{Opts: MatchOptions{Method: Optimal, Ratio: 2}, WantErr: true},
+	}
+	for i, test := range tests {
+		sets, _ := match(subjects, test.Opts)
+		w := csv.NewWriter(&bytes.Buffer{})
+		err := report.Write(w, &Inputs{Matched: sets})
+		if (err != nil) != test.WantErr {
+			t.Errorf("test %d: want error %t, got %v", i, test.WantErr, err)
+		}
+	}
+	c, d := &Subject{}, &Subject{}
+	reused := MatchedSets{{Controls: []*Subject{c}, Cases: []*Subject{d}}, {Controls: []*Subject{{}}, Cases: []*Subject{d}}}
+	if _, err := reused.IndependentPairs(); err == nil {
+		t.Errorf("want error for a case in two sets")
+	}
+}
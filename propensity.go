@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+)
+
+// PropensityModel predicts the probability of a subject belonging to the
+// treated group from its covariates.
+type PropensityModel struct {
+	Covariates []Covariate
+	Fit        *LogisticFit
+	// N is the number of subjects with complete covariates the model was
+	// fitted on.
+	N int
+}
+
+// FitPropensity fits a logistic regression of treated on the covariates.
+// Subjects with a missing covariate are left out.
+func FitPropensity(subjects []*Subject, covariates []Covariate, treated func(*Subject) bool) (*PropensityModel, error) {
+	m := &PropensityModel{Covariates: covariates}
+	var (
+		x [][]float64
+		y []float64
+	)
+	for _, s := range subjects {
+		row, ok := m.row(s)
+		if !ok {
+			continue
+		}
+		x = append(x, row)
+		y = append(y, binary(treated(s)))
+	}
+	fit, err := FitLogistic(x, y)
+	if err != nil {
+		return nil, err
+	}
+	m.Fit = fit
+	m.N = len(x)
+	return m, nil
+}
+
+func (m *PropensityModel) row(s *Subject) ([]float64, bool) {
+	row := make([]float64, len(m.Covariates))
+	for i, c := range m.Covariates {
+		v, ok := c.Value(s)
+		if !ok {
+			return nil, false
+		}
+		row[i] = v
+	}
+	return row, true
+}
+
+// Logit returns the linear predictor of s, i.e. the log odds of s being
+// treated.
+func (m *PropensityModel) Logit(s *Subject) (float64, bool) {
+	row, ok := m.row(s)
+	if !ok {
+		return 0, false
+	}
+	return dot(append([]float64{1}, row...), m.Fit.Coefficients), true
+}
+
+// Score returns the probability of s being treated.
+func (m *PropensityModel) Score(s *Subject) (float64, bool) {
+	logit, ok := m.Logit(s)
+	return logistic(logit), ok
+}
+
+// Distance is the absolute difference of the logits of a and b.
+func (m *PropensityModel) Distance(a, b *Subject) (float64, bool) {
+	la, okA := m.Logit(a)
+	lb, okB := m.Logit(b)
+	return math.Abs(la - lb), okA && okB
+}
+
+// LogitSD returns the standard deviation of the logit among subjects, which
+// is the unit that propensity score calipers are usually given in.
+func (m *PropensityModel) LogitSD(subjects []*Subject) float64 {
+	var logits []float64
+	for _, s := range subjects {
+		if logit, ok := m.Logit(s); ok {
+			logits = append(logits, logit)
+		}
+	}
+	_, variance := meanVariance(logits)
+	return math.Sqrt(variance)
+}
+
+// WritePropensityModel writes the fitted coefficients.
+func WritePropensityModel(w *csv.Writer, m *PropensityModel) error {
+	header := []string{"Term", "Koeffizient", "SE", "z", "p", "Odds Ratio"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	terms := []string{"(Intercept)"}
+	for _, c := range m.Covariates {
+		terms = append(terms, c.Name)
+	}
+	for i, term := range terms {
+		coef, se := m.Fit.Coefficients[i], m.Fit.StdErrors[i]
+		row := []string{
+			term,
+			float64Str(coef),
+			float64Str(se),
+			float64Str(coef / se),
+			float64Str(normalPValue(coef / se)),
+			float64Str(math.Exp(coef)),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	footer := []string{"n", fmt.Sprintf("%d", m.N), "Log-Likelihood", float64Str(m.Fit.LogLikelihood)}
+	return w.Write(footer)
+}
+
+// WritePropensityScores writes the distribution of the scores for each of
+// the groups returned by group.
+func WritePropensityScores(w *csv.Writer, m *PropensityModel, subjects []*Subject, groups []string, group func(*Subject) string) error {
+	header := []string{"Gruppe", "n", "Min", "Q1", "Median", "Q3", "Max", "Mittel"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	scores := map[string][]float64{}
+	for _, s := range subjects {
+		if score, ok := m.Score(s); ok {
+			scores[group(s)] = append(scores[group(s)], score)
+		}
+	}
+	for _, g := range groups {
+		vals := scores[g]
+		mean, _ := meanVariance(vals)
+		row := []string{
+			g,
+			fmt.Sprintf("%d", len(vals)),
+			float64Str(quantile(vals, 0)),
+			float64Str(quantile(vals, 0.25)),
+			float64Str(quantile(vals, 0.5)),
+			float64Str(quantile(vals, 0.75)),
+			float64Str(quantile(vals, 1)),
+			float64Str(mean),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
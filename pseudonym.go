@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradfitz/slice"
+)
+
+// PseudonymMethod selects how study IDs are derived.
+type PseudonymMethod string
+
+const (
+	// SequentialPseudonyms numbers the subjects in the order of their
+	// identifiers. The IDs change when subjects are added.
+	SequentialPseudonyms PseudonymMethod = "sequential"
+	// HMACPseudonyms derives the ID from the identifiers with a secret key,
+	// so the same subject gets the same ID in every run with that key.
+	HMACPseudonyms PseudonymMethod = "hmac"
+)
+
+func ParsePseudonymMethod(val string) (PseudonymMethod, error) {
+	for _, m := range []PseudonymMethod{SequentialPseudonyms, HMACPseudonyms} {
+		if string(m) == val {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("Bad PseudonymMethod: %s", val)
+}
+
+// minPseudonymKeyLength is the minimum length of the secret of HMACPseudonyms.
+const minPseudonymKeyLength = 16
+
+// ReadPseudonymKey reads the secret for HMACPseudonyms from file.
+func ReadPseudonymKey(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < minPseudonymKeyLength {
+		return nil, fmt.Errorf("Bad pseudonym key: %s: shorter than %d bytes", file, minPseudonymKeyLength)
+	}
+	return key, nil
+}
+
+// AssignPseudonyms sets the Pseudonym of every subject. The key is only used
+// by HMACPseudonyms.
+func AssignPseudonyms(subjects Subjects, method PseudonymMethod, key []byte) error {
+	switch method {
+	case SequentialPseudonyms:
+		sorted := append(Subjects{}, subjects...)
+		slice.Sort(sorted, func(i, j int) bool {
+			return subjectLess(sorted[i], sorted[j])
+		})
+		for i, s := range sorted {
+			s.Pseudonym = fmt.Sprintf("P%04d", i+1)
+		}
+	case HMACPseudonyms:
+		if len(key) < minPseudonymKeyLength {
+			return fmt.Errorf("hmac pseudonyms need a key of at least %d bytes", minPseudonymKeyLength)
+		}
+		seen := map[string]*Subject{}
+		for _, s := range subjects {
+			mac := hmac.New(sha256.New, key)
+			fmt.Fprintf(mac, "%s\x00%s", s.ProbeNumber, s.LabBerlinNumber)
+			s.Pseudonym = "P" + hex.EncodeToString(mac.Sum(nil))[:12]
+			if other := seen[s.Pseudonym]; other != nil {
+				return fmt.Errorf("%s and %s have the same identifiers", other, s)
+			}
+			seen[s.Pseudonym] = s
+		}
+	default:
+		return fmt.Errorf("bug: unknown pseudonym method: %s", method)
+	}
+	return nil
+}
+
+// WritePseudonymKey writes the table that links pseudonyms to identities.
+// It must be stored apart from the shared outputs.
+func WritePseudonymKey(w *csv.Writer, subjects Subjects) error {
+	header := []string{"Pseudonym", "Probennummer", "Labor- Berlin Nr.", "Vorname", "Nachname", "Geburtsdatum"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, s := range subjects {
+		row := []string{s.Pseudonym, s.ProbeNumber, s.LabBerlinNumber, s.FirstName, s.LastName, dateStr(s.Birthday)}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StripIdentities removes everything from the subjects that identifies a
+// patient, leaving the Pseudonym. Afterwards Subject.String returns the
+// pseudonym, so it also replaces names in free text like unmatched reasons.
+func StripIdentities(subjects Subjects) {
+	for _, s := range subjects {
+		s.ProbeNumber = ""
+		s.LabBerlinNumber = ""
+		s.FirstName = ""
+		s.LastName = ""
+		s.Birthday = nil
+		s.SamplingDate = nil
+		s.OnsetDate = nil
+		s.stripped = true
+	}
+}
+
+// identifyingColumns are output columns that identify a patient.
+var identifyingColumns = map[string]bool{
+	"Labor- Berlin Nr.": true,
+	"Probennummer":      true,
+	"Vorname":           true,
+	"Nachname":          true,
+	"Name":              true,
+	"Geburtsdatum":      true,
+}
+
+// identifyingFields are the subject fields that StripIdentities removes,
+// whatever the columns they are read from are called.
+var identifyingFields = map[string]bool{
+	"ProbeNumber":     true,
+	"LabBerlinNumber": true,
+	"FirstName":       true,
+	"LastName":        true,
+	"Birthday":        true,
+	"SamplingDate":    true,
+	"OnsetDate":       true,
+}
+
+// removeIdentifyingColumns drops the identifyingColumns from a table whose
+// first record is the header.
+func removeIdentifyingColumns(records [][]string) [][]string {
+	if len(records) == 0 {
+		return records
+	}
+	var keep []int
+	for i, name := range records[0] {
+		if !identifyingColumns[name] {
+			keep = append(keep, i)
+		}
+	}
+	if len(keep) == len(records[0]) {
+		return records
+	}
+	r := make([][]string, len(records))
+	for i, record := range records {
+		for _, j := range keep {
+			if j < len(record) {
+				r[i] = append(r[i], record[j])
+			}
+		}
+	}
+	return r
+}
+
+// insideDir returns true if path is dir or below it.
+func insideDir(path, dir string) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_AssignPseudonyms(t *testing.T) {
+	newSubjects := func() Subjects {
+		return Subjects{
+			{ProbeNumber: "2", LabBerlinNumber: "B", FirstName: "Max", LastName: "Muster"},
+			{ProbeNumber: "1", LabBerlinNumber: "A", FirstName: "Eva", LastName: "Muster"},
+		}
+	}
+	subjects := newSubjects()
+	if err := AssignPseudonyms(subjects, SequentialPseudonyms, nil); err != nil {
+		t.Fatal(err)
+	}
+	if subjects[0].Pseudonym != "P0002" || subjects[1].Pseudonym != "P0001" {
+		t.Errorf("got %s, %s", subjects[0].Pseudonym, subjects[1].Pseudonym)
+	}
+	key := []byte("0123456789abcdef")
+	a, b := newSubjects(), newSubjects()
+	if err := AssignPseudonyms(a, HMACPseudonyms, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := AssignPseudonyms(Subjects{b[1], b[0]}, HMACPseudonyms, key); err != nil {
+		t.Fatal(err)
+	}
+	if a[0].Pseudonym != b[0].Pseudonym || a[0].Pseudonym == a[1].Pseudonym || len(a[0].Pseudonym) != 13 {
+		t.Errorf("want stable distinct pseudonyms, got %s, %s, %s", a[0].Pseudonym, a[1].Pseudonym, b[0].Pseudonym)
+	}
+	if err := AssignPseudonyms(newSubjects(), HMACPseudonyms, []byte("kurz")); err == nil {
+		t.Errorf("want error for short key")
+	}
+	twins := append(newSubjects(), &Subject{ProbeNumber: "1", LabBerlinNumber: "A"})
+	if err := AssignPseudonyms(twins, HMACPseudonyms, key); err == nil {
+		t.Errorf("want error for duplicate identifiers")
+	}
+	StripIdentities(subjects)
+	if s := subjects[0]; s.String() != "P0002" || s.FirstName != "" || s.ProbeNumber != "" {
+		t.Errorf("got %#v", s)
+	}
+	if row := strings.Join(subjectRow(subjects[0]), ","); strings.Contains(row, "Max") {
+		t.Errorf("name in row: %s", row)
+	}
+}
+
+func Test_removeIdentifyingColumns(t *testing.T) {
+	records := [][]string{
+		{"Row", "Pseudonym", "Name", "Alter", "Geburtsdatum"},
+		{"1", "P0001", "Eva Muster", "30", "01.01.1980"},
+		{"2", "P0002"},
+	}
+	want := [][]string{
+		{"Row", "Pseudonym", "Alter"},
+		{"1", "P0001", "30"},
+		{"2", "P0002"},
+	}
+	if got := removeIdentifyingColumns(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_insideDir(t *testing.T) {
+	tests := []struct {
+		Path, Dir string
+		Want      bool
+	}{
+		{"out/Pseudonyme.csv", "out", true},
+		{"out/Schluessel/Pseudonyme.csv", "out/", true},
+		{"./out/../out/x.csv", "out", true},
+		{"Pseudonyme.csv", "out", false},
+		{"../Pseudonyme.csv", "out", false},
+		{"..x/Pseudonyme.csv", ".", true},
+		{"/tmp/Pseudonyme.csv", "out", false},
+	}
+	for i, test := range tests {
+		got, err := insideDir(test.Path, test.Dir)
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		if got != test.Want {
+			t.Errorf("test %d: want %t, got %t", i, test.Want, got)
+		}
+	}
+}
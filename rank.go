@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ValueGroupSubject is a GroupSubject with a numeric value, which is
+// compared between the groups.
+type ValueGroupSubject interface {
+	GroupSubject
+	Value() float64
+}
+
+// groupValue is a ValueGroupSubject that is just a value in a group.
+type groupValue struct {
+	group Group
+	value float64
+}
+
+func (v groupValue) Group() Group {
+	return v.group
+}
+
+func (v groupValue) String() string {
+	return fmt.Sprintf("%f", v.value)
+}
+
+func (v groupValue) Value() float64 {
+	return v.value
+}
+
+// ranks returns the ranks of the values of all groups together, ties get
+// their average rank, and the tie correction term sum(t^3-t) over the tied
+// values.
+func ranks(groups [][]float64) ([][]float64, float64) {
+	type item struct {
+		group, index int
+		value        float64
+	}
+	var items []item
+	r := make([][]float64, len(groups))
+	for g, vals := range groups {
+		r[g] = make([]float64, len(vals))
+		for i, v := range vals {
+			items = append(items, item{g, i, v})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].value < items[j].value
+	})
+	var ties float64
+	for i := 0; i < len(items); {
+		j := i + 1
+		for j < len(items) && items[j].value == items[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, it := range items[i:j] {
+			r[it.group][it.index] = rank
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	return r, ties
+}
+
+func sum(vals []float64) float64 {
+	var s float64
+	for _, v := range vals {
+		s += v
+	}
+	return s
+}
+
+// MannWhitney returns the U statistic of a, the smaller of the U statistics
+// of both groups, and the two-sided p-value from the normal approximation
+// with tie and continuity correction.
+func MannWhitney(a, b []float64) (u, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return math.NaN(), math.NaN()
+	}
+	r, ties := ranks([][]float64{a, b})
+	u1 := sum(r[0]) - n1*(n1+1)/2
+	u = math.Min(u1, n1*n2-u1)
+	n := n1 + n2
+	sigma := math.Sqrt(n1 * n2 / 12 * (n + 1 - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, math.NaN()
+	}
+	z := math.Max(math.Abs(u1-n1*n2/2)-0.5, 0) / sigma
+	return u, normalPValue(z)
+}
+
+// KruskalWallis returns the tie corrected H statistic of the groups with
+// its degrees of freedom and p-value from the chi-square distribution.
+func KruskalWallis(groups [][]float64) (h float64, df int, p float64) {
+	r, ties := ranks(groups)
+	var n float64
+	for _, vals := range groups {
+		n += float64(len(vals))
+	}
+	df = len(groups) - 1
+	if df < 1 || n < 2 {
+		return math.NaN(), df, math.NaN()
+	}
+	for _, rg := range r {
+		s := sum(rg)
+		h += s * s / float64(len(rg))
+	}
+	h = 12/(n*(n+1))*h - 3*(n+1)
+	if correction := 1 - ties/(n*n*n-n); correction > 0 {
+		h /= correction
+	} else {
+		return math.NaN(), df, math.NaN()
+	}
+	return h, df, chiSquarePValue(h, df)
+}
+
+// DunnComparison is the post-hoc comparison of two groups after
+// KruskalWallis.
+type DunnComparison struct {
+	A, B int
+	Z    float64
+	P    float64
+	// PAdjusted is P multiplied with the number of comparisons
+	// (Bonferroni), at most 1.
+	PAdjusted float64
+}
+
+// Dunn compares the mean ranks of every pair of groups with Dunn's test.
+func Dunn(groups [][]float64) []DunnComparison {
+	r, ties := ranks(groups)
+	var n float64
+	for _, vals := range groups {
+		n += float64(len(vals))
+	}
+	variance := n*(n+1)/12 - ties/(12*(n-1))
+	comparisons := float64(len(groups) * (len(groups) - 1) / 2)
+	var results []DunnComparison
+	for a := range groups {
+		for b := a + 1; b < len(groups); b++ {
+			na, nb := float64(len(groups[a])), float64(len(groups[b]))
+			z := (sum(r[a])/na - sum(r[b])/nb) / math.Sqrt(variance*(1/na+1/nb))
+			p := normalPValue(z)
+			results = append(results, DunnComparison{a, b, z, p, math.Min(1, p*comparisons)})
+		}
+	}
+	return results
+}
+
+// WriteGroupStats writes n, median, quartiles, mean and SD of each group and
+// compares the groups: two with Mann-Whitney U, more with Kruskal-Wallis and
+// Dunn's post-hoc tests. Empty groups and n/a are described but not
+// compared. The subjects must be ValueGroupSubjects.
+func WriteGroupStats(w *csv.Writer, groups []Group, subjects []GroupSubject) error {
+	values := make([][]float64, len(groups))
+	for _, s := range subjects {
+		vs, ok := s.(ValueGroupSubject)
+		if !ok {
+			return fmt.Errorf("bug: %T has no value", s)
+		}
+		found := false
+		for g, group := range groups {
+			if group.String() == s.Group().String() {
+				values[g] = append(values[g], vs.Value())
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s did not match any group", s.Group())
+		}
+	}
+	header := []string{"Gruppe", "n", "Median", "Q1", "Q3", "Mittel", "SD"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	var (
+		names    []string
+		compared [][]float64
+	)
+	for g, group := range groups {
+		vals := values[g]
+		mean, variance := meanVariance(vals)
+		row := []string{
+			group.String(),
+			fmt.Sprintf("%d", len(vals)),
+			float64Str(quantile(vals, 0.5)),
+			float64Str(quantile(vals, 0.25)),
+			float64Str(quantile(vals, 0.75)),
+			float64Str(mean),
+			float64Str(math.Sqrt(variance)),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+		if len(vals) > 0 && group.String() != "n/a" {
+			names = append(names, group.String())
+			compared = append(compared, vals)
+		}
+	}
+	if len(compared) < 2 {
+		return nil
+	}
+	if err := w.Write([]string{"Test", "Vergleich", "Statistik", "Wert", "p", "p (Bonferroni)"}); err != nil {
+		return err
+	}
+	if len(compared) == 2 {
+		u, p := MannWhitney(compared[0], compared[1])
+		row := []string{"Mann-Whitney U", names[0] + " - " + names[1], "U", float64Str(u), float64Str(p), ""}
+		return w.Write(row)
+	}
+	h, df, p := KruskalWallis(compared)
+	row := []string{"Kruskal-Wallis", "alle", fmt.Sprintf("H (%d FG)", df), float64Str(h), float64Str(p), ""}
+	if err := w.Write(row); err != nil {
+		return err
+	}
+	for _, c := range Dunn(compared) {
+		row := []string{"Dunn", names[c.A] + " - " + names[c.B], "z", float64Str(c.Z), float64Str(c.P), float64Str(c.PAdjusted)}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
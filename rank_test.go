@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_MannWhitney(t *testing.T) {
+	tests := []struct {
+		A, B  []float64
+		U, P  float64
+		IsNaN bool
+	}{
+		{A: []float64{1, 2, 3, 4, 5}, B: []float64{6, 7, 8, 9, 10}, U: 0, P: 0.012185},
+		{A: []float64{1, 2, 2, 3}, B: []float64{2, 3, 4, 4}, U: 2.5, P: 0.134169},
+		{A: []float64{1, 2}, B: nil, IsNaN: true},
+	}
+	for i, test := range tests {
+		u, p := MannWhitney(test.A, test.B)
+		if test.IsNaN {
+			if !math.IsNaN(p) {
+				t.Errorf("test %d: want NaN, got %f", i, p)
+			}
+			continue
+		}
+		if math.Abs(u-test.U) > 1e-9 || math.Abs(p-test.P) > 1e-6 {
+			t.Errorf("test %d: got U=%f p=%f", i, u, p)
+		}
+	}
+}
+
+func Test_KruskalWallis(t *testing.T) {
+	tests := []struct {
+		Groups [][]float64
+		H      float64
+		DF     int
+	}{
+		{Groups: [][]float64{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}, H: 7.2, DF: 2},
+		{Groups: [][]float64{{1, 2, 2}, {2, 3, 3, 5}, {4, 5, 6}}, H: 6.469340, DF: 2},
+	}
+	for i, test := range tests {
+		h, df, p := KruskalWallis(test.Groups)
+		if math.Abs(h-test.H) > 1e-6 || df != test.DF || math.Abs(p-math.Exp(-h/2)) > 1e-9 {
+			t.Errorf("test %d: got H=%f df=%d p=%f", i, h, df, p)
+		}
+	}
+}
+
+func Test_Dunn(t *testing.T) {
+	got := Dunn([][]float64{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}})
+	want := []DunnComparison{
+		{A: 0, B: 1, Z: -1.341641, P: 0.179712, PAdjusted: 0.539137},
+		{A: 0, B: 2, Z: -2.683282, P: 0.007290, PAdjusted: 0.021871},
+		{A: 1, B: 2, Z: -1.341641, P: 0.179712, PAdjusted: 0.539137},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d comparisons", len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.A != w.A || g.B != w.B || math.Abs(g.Z-w.Z) > 1e-6 || math.Abs(g.P-w.P) > 1e-6 || math.Abs(g.PAdjusted-w.PAdjusted) > 1e-6 {
+			t.Errorf("test %d: got %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func Test_WriteGroupStats(t *testing.T) {
+	var subjects []GroupSubject
+	for _, v := range []float64{1, 2, 3, 4} {
+		subjects = append(subjects, groupValue{Level("a"), v})
+	}
+	for _, v := range []float64{5, 6, 7} {
+		subjects = append(subjects, groupValue{Level("b"), v})
+	}
+	subjects = append(subjects, groupValue{Level("n/a"), 100})
+	tests := []struct {
+		Groups []Group
+		Want   []string
+	}{
+		{
+			Groups: []Group{Level("a"), Level("b"), Level("n/a")},
+			Want: []string{
+				"a,4,2.500000,1.750000,3.250000,2.500000,1.290994",
+				"n/a,1,100.000000,100.000000,100.000000,100.000000,n/a",
+				"Mann-Whitney U,a - b,U,0.000000",
+			},
+		},
+		{
+			Groups: []Group{Level("a"), Level("b"), Level("c"), Level("n/a")},
+			Want:   []string{"c,0,n/a,n/a,n/a,n/a,n/a", "Mann-Whitney U,a - b"},
+		},
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := WriteGroupStats(w, test.Groups, subjects); err != nil {
+			t.Fatal(err)
+		}
+		w.Flush()
+		for _, want := range test.Want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("test %d: want %q in %s", i, want, buf.String())
+			}
+		}
+	}
+	w := csv.NewWriter(&bytes.Buffer{})
+	if err := WriteGroupStats(w, []Group{Level("a")}, subjects); err == nil {
+		t.Errorf("want error for values outside the groups")
+	}
+}
@@ -0,0 +1,876 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Input is something a report is computed from.
+type Input string
+
+const (
+	// SubjectsInput are all subjects as read from the input, including the
+	// MS patients, the age mismatches and the mapping.
+	SubjectsInput Input = "subjects"
+	// MatchedInput is the matching of controls and cases.
+	MatchedInput Input = "matched"
+	// MSMatchedInput is the matching of IgG positive and negative MS patients.
+	MSMatchedInput Input = "ms-matched"
+	// StabilityInput are the repeated matchings of -stability-runs.
+	StabilityInput Input = "stability"
+	// PropensityInput is the propensity model of -distance=propensity.
+	PropensityInput Input = "propensity"
+	// MSPropensityInput is the propensity model of -ms-distance=propensity.
+	MSPropensityInput Input = "ms-propensity"
+)
+
+// Inputs holds everything reports are computed from. Fields of inputs that
+// no report needs may be left empty.
+type Inputs struct {
+	Subjects      Subjects
+	MSPatients    Subjects
+	AgeMismatches []AgeMismatch
+	Mapping       Mapping
+
+	Matched   MatchedSets
+	Unmatched []Unmatched
+	Strata    Strata
+
+	MSMatched []Match
+	MSStrata  Strata
+
+	Stability   *PairStability
+	MSStability *PairStability
+
+	PSModel   *PropensityModel
+	MSPSModel *PropensityModel
+
+	// CLRCovariates are the covariates of the conditional logistic
+	// regression on the matched sets.
+	CLRCovariates []Covariate
+}
+
+// Has returns false for the optional inputs that were not computed in this
+// run, e.g. the propensity model if matching does not use one.
+func (in *Inputs) Has(i Input) bool {
+	switch i {
+	case StabilityInput:
+		return in.Stability != nil && in.MSStability != nil
+	case PropensityInput:
+		return in.PSModel != nil
+	case MSPropensityInput:
+		return in.MSPSModel != nil
+	}
+	return true
+}
+
+// Missing returns the first input of r that in does not have.
+func (in *Inputs) Missing(r Report) (Input, bool) {
+	for _, i := range r.Inputs() {
+		if !in.Has(i) {
+			return i, true
+		}
+	}
+	return "", false
+}
+
+// Report is an output table.
+type Report interface {
+	// Name is the file name without extension.
+	Name() string
+	Description() string
+	Inputs() []Input
+	Write(w *csv.Writer, in *Inputs) error
+}
+
+// NewReport returns a Report that calls write.
+func NewReport(name, description string, inputs []Input, write func(w *csv.Writer, in *Inputs) error) Report {
+	return &funcReport{name, description, inputs, write}
+}
+
+type funcReport struct {
+	name        string
+	description string
+	inputs      []Input
+	write       func(w *csv.Writer, in *Inputs) error
+}
+
+func (r *funcReport) Name() string        { return r.name }
+func (r *funcReport) Description() string { return r.description }
+func (r *funcReport) Inputs() []Input     { return r.inputs }
+
+func (r *funcReport) Write(w *csv.Writer, in *Inputs) error {
+	return r.write(w, in)
+}
+
+// RenderReport returns the records written by r, without the
+// identifyingColumns unless identifying is set. The records are returned
+// even if r fails, along with the error.
+func RenderReport(r Report, in *Inputs, identifying bool) ([][]string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	writeErr := r.Write(w, in)
+	w.Flush()
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not read back: %s", err)
+	}
+	if !identifying {
+		records = removeIdentifyingColumns(records)
+	}
+	return records, writeErr
+}
+
+// Registry holds reports in the order they are registered, which is the
+// order they are written in.
+type Registry struct {
+	reports []Report
+	// effects are the Effects of the contingency tables that have one,
+	// by table name.
+	effects map[string]Effect
+	// tables are the contingency tables by name.
+	tables map[string]func(in *Inputs) Contingency
+	// exposed is the exposed level of each McNemar report.
+	exposed map[string]float64
+}
+
+// Register adds r. It panics if a report of the same name is registered
+// already.
+func (reg *Registry) Register(r Report) {
+	if reg.Lookup(r.Name()) != nil {
+		panic(fmt.Sprintf("report %s registered twice", r.Name()))
+	}
+	reg.reports = append(reg.reports, r)
+}
+
+// Lookup returns the report with the given name, or nil.
+func (reg *Registry) Lookup(name string) Report {
+	for _, r := range reg.reports {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Reports returns all reports in registration order.
+func (reg *Registry) Reports() []Report {
+	return append([]Report{}, reg.reports...)
+}
+
+// Select returns the reports named in only, or all if only is empty, minus
+// the ones named in exclude, in registration order.
+func (reg *Registry) Select(only, exclude []string) ([]Report, error) {
+	selected := map[string]bool{}
+	for _, name := range only {
+		if reg.Lookup(name) == nil {
+			return nil, fmt.Errorf("Bad report: %s", name)
+		}
+		selected[name] = true
+	}
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		if reg.Lookup(name) == nil {
+			return nil, fmt.Errorf("Bad report: %s", name)
+		}
+		excluded[name] = true
+	}
+	var r []Report
+	for _, report := range reg.reports {
+		if (len(only) == 0 || selected[report.Name()]) && !excluded[report.Name()] {
+			r = append(r, report)
+		}
+	}
+	return r, nil
+}
+
+// ParseReportNames parses a comma separated list of report names.
+func ParseReportNames(val string) []string {
+	var names []string
+	for _, name := range strings.Split(val, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NeededInputs returns the inputs of all reports.
+func NeededInputs(reports []Report) map[Input]bool {
+	needed := map[Input]bool{}
+	for _, r := range reports {
+		for _, i := range r.Inputs() {
+			needed[i] = true
+		}
+	}
+	return needed
+}
+
+// WriteReportList writes one line per report with its name, inputs and
+// description.
+func WriteReportList(w io.Writer, reports []Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Name\tInputs\tDescription")
+	for _, r := range reports {
+		var inputs []string
+		for _, i := range r.Inputs() {
+			inputs = append(inputs, string(i))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name(), strings.Join(inputs, ","), r.Description())
+	}
+	return tw.Flush()
+}
+
+// runListReports implements the list-reports subcommand. It takes the flags
+// that add reports, so their names can be looked up for -only and -exclude.
+func runListReports(args []string) {
+	fs := flag.NewFlagSet("list-reports", flag.ExitOnError)
+	mappingFile := fs.String("mapping", "", "JSON file that maps input columns to subject fields, for the variables used by -tables and -group-values")
+	tables := fs.String("tables", "", "Also list the reports of these -tables")
+	groupValues := fs.String("group-values", "", "Also list the reports of these -group-values")
+	mcNemar := fs.String("mc-nemar", "", "Also list the reports of these -mc-nemar exposures")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "./main list-reports [flags]\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	fs.Parse(args)
+	if *mappingFile != "" {
+		mapping, err := ReadMapping(*mappingFile)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		RegisterVariables(mapping.Variables)
+	}
+	reg := DefaultReports()
+	if err := reg.RegisterFlagReports(*tables, *groupValues, *mcNemar); err != nil {
+		fatalf("%s", err)
+	}
+	if err := WriteReportList(os.Stdout, reg.Reports()); err != nil {
+		fatalf("%s", err)
+	}
+}
+
+// RegisterFlagReports adds the reports of the -tables, -group-values and
+// -mc-nemar flags.
+func (reg *Registry) RegisterFlagReports(tables, groupValues, mcNemar string) error {
+	pairs, err := ParseCategoryPairs(tables)
+	if err != nil {
+		return fmt.Errorf("tables: %s", err)
+	}
+	values, err := parseGroupValues(groupValues)
+	if err != nil {
+		return fmt.Errorf("group-values: %s", err)
+	}
+	var exposures []Exposure
+	if mcNemar != "" {
+		if exposures, err = ParseExposures(mcNemar); err != nil {
+			return fmt.Errorf("mc-nemar: %s", err)
+		}
+	}
+	if err := reg.RegisterMcNemar(exposures); err != nil {
+		return fmt.Errorf("mc-nemar: %s", err)
+	}
+	reg.RegisterTables(pairs)
+	reg.RegisterGroupValues(values)
+	return nil
+}
+
+// DefaultReports returns a registry with the built-in reports.
+func DefaultReports() *Registry {
+	r := &Registry{}
+	r.Register(NewReport("Patienten-MS-Toxo-Matched-EDSS", "EDSS of the IgG positive and negative MS patients of the MS matching", []Input{MSMatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"Toxo-IgG Positiv", "Toxo-IgG Negativ"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		results := map[Status][]float64{}
+		for _, m := range in.MSMatched {
+			if m.A.EDSS == nil || m.B.EDSS == nil {
+				continue
+			}
+			for _, s := range append(Subjects{}, m.A, m.B) {
+				results[s.IgG] = append(results[s.IgG], *s.EDSS)
+			}
+		}
+		groups := []Status{true, false}
+		for i := 0; ; i++ {
+			row := make([]string, len(groups))
+			found := false
+			for j, group := range groups {
+				vals := results[group]
+				if i < len(vals) {
+					found = true
+					row[j] = fmt.Sprintf("%f", vals[i])
+				}
+			}
+			if !found {
+				break
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.Register(NewReport("Patienten-MS-Toxo-Matched", "Pairs of IgG positive and negative MS patients", []Input{MSMatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"Row", "Pseudonym", "Name", "Geschlecht", "Alter", "Erkrankungsdauer", "IgG", "Diagnose", "Geburtsdatum", "EDSS", "CMRT_T2", "CMRT_GD", "SMRT_T2", "SMRT_GD", "Match Score"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for i, match := range in.MSMatched {
+			for j, s := range append(Subjects{}, match.A, match.B) {
+				row := []string{
+					fmt.Sprintf("%d", i*2+j+1),
+					s.Pseudonym,
+					fmt.Sprintf("%s %s", s.FirstName, s.LastName),
+					fmt.Sprintf("%s", s.Gender),
+					fmt.Sprintf("%f", s.Age),
+					fmt.Sprintf("%s", float64PtrStr(s.SickDuration)),
+					fmt.Sprintf("%s", s.IgG),
+					fmt.Sprintf("%s", s.Diagnosis),
+					dateStr(s.Birthday),
+					fmt.Sprintf("%s", float64PtrStr(s.EDSS)),
+					fmt.Sprintf("%s", s.CMRT_T2),
+					fmt.Sprintf("%s", s.CMRT_GD),
+					fmt.Sprintf("%s", s.SMRT_T2),
+					fmt.Sprintf("%s", s.SMRT_GD),
+					fmt.Sprintf("%.2f", match.Score),
+				}
+				if err := w.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+	r.Register(NewReport("Patienten", "All subjects", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		return writeSubjects(w, in.Subjects)
+	}))
+	r.Register(NewReport("Patienten-Matched", "Matched sets of controls and cases", []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		return writeMatchedSets(w, in.Matched)
+	}))
+	r.Register(NewReport("Balance", "Covariate balance before and after matching", []Input{SubjectsInput, MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		cohorts := []string{"Unmatched", "Matched"}
+		return WriteBalance(w, Covariates, cohorts, [][]*Subject{in.Subjects, in.Matched.Unique()}, []Weights{nil, in.Matched.Weights()})
+	}))
+	r.Register(NewReport("Patienten-Matched-Gewichte", "Weights of the matched sets", []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		return WriteSetWeights(w, in.Matched)
+	}))
+	r.Register(NewReport("Patienten-Matched-Strata", "Subjects per exact matching stratum before and after matching", []Input{SubjectsInput, MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		groups := []string{"Kontrolle", "Fall"}
+		return WriteStrata(w, in.Strata, in.Subjects, in.Matched.Unique(), groups, (*Subject).Role)
+	}))
+	r.Register(NewReport("Patienten-MS-Toxo-Matched-Strata", "MS patients per exact matching stratum before and after the MS matching", []Input{SubjectsInput, MSMatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		var matchedSubjects []*Subject
+		for _, m := range in.MSMatched {
+			matchedSubjects = append(matchedSubjects, m.A, m.B)
+		}
+		groups := []string{"positiv", "negativ"}
+		return WriteStrata(w, in.MSStrata, in.MSPatients, matchedSubjects, groups, func(s *Subject) string {
+			return s.IgG.String()
+		})
+	}))
+	r.Register(NewReport("Alter-Abgleich", "Ages that differ from the ones computed from dates", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		return WriteAgeMismatches(w, in.AgeMismatches, in.Mapping)
+	}))
+	r.Register(NewReport("Patienten-Unmatched", "Subjects left unmatched and why", []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		return writeUnmatched(w, in.Unmatched)
+	}))
+	r.Register(NewReport("Patienten-Matched-Altersunterschied", "Age differences of the matched pairs", []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		return writeHistogram(w, in.Matched.AgeDiffs())
+	}))
+	r.RegisterContingency("IgG-MS-GK-Unmatched", "IgG by diagnosis, all subjects", []Input{SubjectsInput}, func(in *Inputs) Contingency {
+		top := []string{"MS", "GK"}
+		left := []string{"positiv", "negativ"}
+		return NewContingency(top, left, IgG_MS_GKSubjects(in.Subjects, nil))
+	})
+	r.RegisterContingency("IgG-MS-GK-Matched", "IgG by diagnosis, matched subjects", []Input{MatchedInput}, func(in *Inputs) Contingency {
+		top := []string{"MS", "GK"}
+		left := []string{"positiv", "negativ"}
+		return NewContingency(top, left, IgG_MS_GKSubjects(in.Matched.Unique(), in.Matched.Weights()))
+	})
+	r.RegisterContingency("IgM-MS-GK-Unmatched", "IgM by diagnosis, all subjects", []Input{SubjectsInput}, func(in *Inputs) Contingency {
+		top := []string{"MS", "GK"}
+		left := []string{"positiv", "negativ"}
+		return NewContingency(top, left, IgM_MS_GKSubjects(in.Subjects, nil))
+	})
+	r.RegisterContingency("IgM-MS-GK-Matched", "IgM by diagnosis, matched subjects", []Input{MatchedInput}, func(in *Inputs) Contingency {
+		top := []string{"MS", "GK"}
+		left := []string{"positiv", "negativ"}
+		return NewContingency(top, left, IgM_MS_GKSubjects(in.Matched.Unique(), in.Matched.Weights()))
+	})
+	r.RegisterContingency("ANA-Nikotinabusus-MS", "ANA by Nikotinabusus of the MS patients", []Input{SubjectsInput}, func(in *Inputs) Contingency {
+		top := []string{"ja", "nein"}
+		left := []string{"positiv", "negativ"}
+		return NewContingency(top, left, ANA_Nikotinabusus_MS_Subjects(in.Subjects, nil))
+	})
+	r.RegisterContingency("IgG-Treatment", "IgG by therapy group of the MS patients", []Input{SubjectsInput}, func(in *Inputs) Contingency {
+		top := []string{"Positiv", "Negativ"}
+		left := []string{string(Untreated), string(BaseMedication), string(EscalationTherapy)}
+		c := NewContingency(top, left, IgG_TreatmentSubjects(in.Subjects))
+		c.Title = ""
+		return c
+	})
+	r.Register(NewReport("IgG-Titer-IgG-Gesamt", "IgG titer and total IgG", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"IgG Gesamt", "IgG Titer"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, s := range in.Subjects {
+			if s.IgGTotal == nil {
+				continue
+			}
+			row := []string{
+				fmt.Sprintf("%f", *s.IgGTotal),
+				fmt.Sprintf("%f", s.IgGTiter),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.Register(NewReport("IgG-Titer-Erkrankungsdauer", "IgG titer and Erkrankungsdauer", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"Erkrankungsdauer", "IgG Titer"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, s := range in.Subjects {
+			if s.SickDuration == nil {
+				continue
+			}
+			if *s.SickDuration < 0 {
+				continue
+			}
+			row := []string{
+				fmt.Sprintf("%f", *s.SickDuration),
+				fmt.Sprintf("%f", s.IgGTiter),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.Register(NewReport("EDSS", "EDSS values", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"EDSS"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, s := range in.Subjects {
+			if s.EDSS == nil {
+				continue
+			}
+			row := []string{
+				fmt.Sprintf("%f", *s.EDSS),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.Register(NewReport("IgG-Titer-EDSS", "IgG titer and EDSS", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"EDSS", "IgG Titer"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, s := range in.Subjects {
+			if s.EDSS == nil {
+				continue
+			}
+			row := []string{
+				fmt.Sprintf("%f", *s.EDSS),
+				fmt.Sprintf("%f", s.IgGTiter),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.Register(NewReport("IgG-Titer-Alter", "IgG titer and age", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{"Alter", "IgG Titer"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, s := range in.Subjects {
+			row := []string{
+				fmt.Sprintf("%f", s.Age),
+				fmt.Sprintf("%f", s.IgGTiter),
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.RegisterGroups("IgG-Titer-SMRT-GD", "IgG titer by SMRT_GD", []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+		groups := []Group{
+			NASPositiv,
+			NASNegativ,
+			NASNA,
+		}
+		groupSubjects := make([]GroupSubject, len(in.Subjects))
+		for i, s := range in.Subjects {
+			groupSubjects[i] = SMRT_GDSubject{s}
+		}
+		return groups, groupSubjects
+	})
+	r.RegisterGroups("IgG-Titer-CMRT-GD", "IgG titer by CMRT_GD", []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+		groups := []Group{
+			NASPositiv,
+			NASNegativ,
+			NASNA,
+		}
+		groupSubjects := make([]GroupSubject, len(in.Subjects))
+		for i, s := range in.Subjects {
+			groupSubjects[i] = CMRT_GDSubject{s}
+		}
+		return groups, groupSubjects
+	})
+	r.Register(NewReport("CMRT-T2-Counts", "CMRT_T2 lesion counts by diagnosis, matched subjects", []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{""}
+		for _, dia := range Diagnoses {
+			header = append(header, string(dia))
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		vals := []string{"0", "<6", ">=6", "n/a"}
+		weights := in.Matched.Weights()
+		for _, val := range vals {
+			results := []string{val}
+			for _, dia := range Diagnoses {
+				count := 0.0
+				for _, s := range in.Matched.Unique() {
+					if s.Diagnosis != dia {
+						continue
+					}
+					v := s.CMRT_T2.String()
+					if v != val {
+						continue
+					}
+					count += weights.Of(s)
+				}
+				results = append(results, strconv.FormatFloat(count, 'f', -1, 64))
+			}
+			if err := w.Write(results); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	r.RegisterGroups("IgG-Titer-CMRT-T2", "IgG titer by CMRT_T2 lesion count", []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+		groups := []Group{
+			NewNARelInt(NewRelInt(Eq, 0), false),
+			NewNARelInt(NewRelInt(Lt, 6), false),
+			NewNARelInt(NewRelInt(GtEq, 6), false),
+			NewNARelInt(RelInt{}, true),
+		}
+		groupSubjects := make([]GroupSubject, len(in.Subjects))
+		for i, s := range in.Subjects {
+			groupSubjects[i] = CMRTSubject{s}
+		}
+		return groups, groupSubjects
+	})
+	r.RegisterGroups("IgG-Titer-SMRT-T2", "IgG titer by SMRT_T2 lesion count", []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+		groups := []Group{
+			NewNARelInt(NewRelInt(Eq, 0), false),
+			NewNARelInt(NewRelInt(Lt, 3), false),
+			NewNARelInt(NewRelInt(GtEq, 3), false),
+			NewNARelInt(RelInt{}, true),
+		}
+		groupSubjects := make([]GroupSubject, len(in.Subjects))
+		for i, s := range in.Subjects {
+			groupSubjects[i] = SMRTSubject{s}
+		}
+		return groups, groupSubjects
+	})
+	r.RegisterContingency("Nikotinabusus-MS-GK-Unmatched", "Nikotinabusus by diagnosis, all subjects", []Input{SubjectsInput}, func(in *Inputs) Contingency {
+		top := []string{"GK", "MS"}
+		left := []string{string(Yes), string(No), string(NA)}
+		c := NewContingency(top, left, Nikotinabusus_MS_GKSubjects(in.Subjects))
+		c.Title = "Nikotinabusus"
+		return c
+	})
+	r.Register(NewReport("IgG-Titer-Nikotinabusus", "IgG titer by Nikotinabusus", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{}
+		groups := []YesNoNA{Yes, No}
+		for _, group := range groups {
+			header = append(header, string(group))
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		results := map[YesNoNA][]float64{}
+		for _, s := range in.Subjects {
+			if s.Nikotinabusus == NA {
+				continue
+			}
+			results[s.Nikotinabusus] = append(results[s.Nikotinabusus], s.IgGTiter)
+		}
+		i := 0
+		for {
+			row := make([]string, len(Diagnoses))
+			found := false
+			for g, group := range groups {
+				if i < len(results[group]) {
+					found = true
+					row[g] = fmt.Sprintf("%f", results[group][i])
+				}
+			}
+			if !found {
+				break
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+			i++
+		}
+		return nil
+	}))
+	r.registerGroupStats("IgG-Titer-Nikotinabusus", []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+		groups := []Group{Level(Yes), Level(No)}
+		var groupSubjects []GroupSubject
+		for _, s := range in.Subjects {
+			if s.Nikotinabusus != NA {
+				groupSubjects = append(groupSubjects, groupValue{Level(s.Nikotinabusus), s.IgGTiter})
+			}
+		}
+		return groups, groupSubjects
+	})
+	r.Register(NewReport("IgG-Titer-Unmatched", "IgG titer by diagnosis, all subjects", []Input{SubjectsInput}, func(w *csv.Writer, in *Inputs) error {
+		header := []string{}
+		for _, group := range Diagnoses {
+			header = append(header, string(group))
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		results := map[Diagnosis][]float64{}
+		for _, s := range in.Subjects {
+			results[s.Diagnosis] = append(results[s.Diagnosis], s.IgGTiter)
+		}
+		i := 0
+		for {
+			row := make([]string, len(Diagnoses))
+			found := false
+			for g, group := range Diagnoses {
+				if i < len(results[group]) {
+					found = true
+					row[g] = fmt.Sprintf("%f", results[group][i])
+				}
+			}
+			if !found {
+				break
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+			i++
+		}
+		return nil
+	}))
+	r.registerGroupStats("IgG-Titer-Unmatched", []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+		var groups []Group
+		for _, d := range Diagnoses {
+			groups = append(groups, Level(d))
+		}
+		var groupSubjects []GroupSubject
+		for _, s := range in.Subjects {
+			groupSubjects = append(groupSubjects, groupValue{Level(s.Diagnosis), s.IgGTiter})
+		}
+		return groups, groupSubjects
+	})
+	// IgG negative has always been the risk factor of this table.
+	if err := r.RegisterMcNemar([]Exposure{{mustCovariate("IgG"), 0}}); err != nil {
+		panic(err)
+	}
+	r.Register(NewReport("Konditionale-Logistische-Regression", "Conditional logistic regression of being a case on -clr-covariates in the matched sets", []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+		m, err := FitConditionalLogistic(in.Matched, in.CLRCovariates)
+		if err != nil {
+			return err
+		}
+		return WriteConditionalLogisticModel(w, m)
+	}))
+	defaultEffects := map[string]Effect{
+		"IgG-MS-GK-Unmatched":  {Exposed: "MS", Outcome: "positiv"},
+		"IgG-MS-GK-Matched":    {Exposed: "MS", Outcome: "positiv"},
+		"IgM-MS-GK-Unmatched":  {Exposed: "MS", Outcome: "positiv"},
+		"IgM-MS-GK-Matched":    {Exposed: "MS", Outcome: "positiv"},
+		"ANA-Nikotinabusus-MS": {Exposed: "ja", Outcome: "positiv"},
+	}
+	for name, e := range defaultEffects {
+		if err := r.SetEffect(name, e, nil); err != nil {
+			panic(err)
+		}
+	}
+	r.Register(NewReport("Patienten-Matched-Stabilitaet", "How often each matched pair recurs in the -stability-runs matchings", []Input{MatchedInput, StabilityInput}, func(w *csv.Writer, in *Inputs) error {
+		return in.Stability.Write(w, [2]string{"Kontrolle", "Fall"}, in.Matched.pairKeys())
+	}))
+	r.Register(NewReport("Patienten-MS-Toxo-Matched-Stabilitaet", "How often each MS pair recurs in the -stability-runs matchings", []Input{MSMatchedInput, StabilityInput}, func(w *csv.Writer, in *Inputs) error {
+		return in.MSStability.Write(w, [2]string{"Patient A", "Patient B"}, matchKeys(in.MSMatched))
+	}))
+	r.Register(NewReport("Propensity-Score-Modell", "Coefficients of the propensity model of -distance=propensity", []Input{PropensityInput}, func(w *csv.Writer, in *Inputs) error {
+		return WritePropensityModel(w, in.PSModel)
+	}))
+	r.Register(NewReport("Propensity-Score-Verteilung", "Propensity scores of MS patients and controls", []Input{SubjectsInput, PropensityInput}, func(w *csv.Writer, in *Inputs) error {
+		groups := []string{"MS", "GK"}
+		return WritePropensityScores(w, in.PSModel, in.Subjects, groups, func(s *Subject) string {
+			if s.Diagnosis == GK {
+				return "GK"
+			}
+			return "MS"
+		})
+	}))
+	r.Register(NewReport("Patienten-MS-Toxo-Propensity-Score-Modell", "Coefficients of the propensity model of -ms-distance=propensity", []Input{MSPropensityInput}, func(w *csv.Writer, in *Inputs) error {
+		return WritePropensityModel(w, in.MSPSModel)
+	}))
+	r.Register(NewReport("Patienten-MS-Toxo-Propensity-Score-Verteilung", "Propensity scores of IgG positive and negative MS patients", []Input{SubjectsInput, MSPropensityInput}, func(w *csv.Writer, in *Inputs) error {
+		groups := []string{"positiv", "negativ"}
+		return WritePropensityScores(w, in.MSPSModel, in.MSPatients, groups, func(s *Subject) string {
+			return s.IgG.String()
+		})
+	}))
+	return r
+}
+
+func mustCovariate(name string) Covariate {
+	c, err := CovariateByName(name)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// RegisterContingency adds a report of the table and one of its tests with
+// the suffix -Statistik. The tests include odds and risk ratios if the table
+// has an Effect, see SetEffect.
+func (reg *Registry) RegisterContingency(name, description string, inputs []Input, table func(in *Inputs) Contingency) {
+	reg.Register(NewReport(name, description, inputs, func(w *csv.Writer, in *Inputs) error {
+		return table(in).Write(w)
+	}))
+	reg.Register(NewReport(name+"-Statistik", "Chi-square and Fisher's exact test of "+name, inputs, func(w *csv.Writer, in *Inputs) error {
+		var effect *Effect
+		if e, ok := reg.effects[name]; ok {
+			effect = &e
+		}
+		return WriteContingencyStats(w, table(in), effect)
+	}))
+	if reg.tables == nil {
+		reg.tables = map[string]func(in *Inputs) Contingency{}
+	}
+	reg.tables[name] = table
+}
+
+// SetEffect sets the exposed column and outcome row of the contingency
+// table with the given name. It checks that the table is 2x2 and has the
+// named levels, which may depend on the subjects, but not on the matching.
+func (reg *Registry) SetEffect(name string, e Effect, subjects Subjects) error {
+	table, ok := reg.tables[name]
+	if !ok {
+		return fmt.Errorf("Bad contingency table: %s", name)
+	}
+	known, _ := table(&Inputs{Subjects: subjects}).Known()
+	if _, err := known.TwoByTwo(e); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	if reg.effects == nil {
+		reg.effects = map[string]Effect{}
+	}
+	reg.effects[name] = e
+	return nil
+}
+
+// RegisterGroups adds a report of the values per group and one with the
+// suffix -Statistik that describes and compares the groups.
+func (reg *Registry) RegisterGroups(name, description string, inputs []Input, groups func(in *Inputs) ([]Group, []GroupSubject)) {
+	reg.Register(NewReport(name, description, inputs, func(w *csv.Writer, in *Inputs) error {
+		g, subjects := groups(in)
+		return WriteGroupValues(w, g, subjects)
+	}))
+	reg.registerGroupStats(name, inputs, groups)
+}
+
+func (reg *Registry) registerGroupStats(name string, inputs []Input, groups func(in *Inputs) ([]Group, []GroupSubject)) {
+	reg.Register(NewReport(name+"-Statistik", "Mann-Whitney U or Kruskal-Wallis test of "+name, inputs, func(w *csv.Writer, in *Inputs) error {
+		g, subjects := groups(in)
+		return WriteGroupStats(w, g, subjects)
+	}))
+}
+
+// RegisterMcNemar adds a McNemar test of the matched pairs for each binary
+// exposure. A covariate can only be registered with one exposed level.
+func (reg *Registry) RegisterMcNemar(exposures []Exposure) error {
+	for _, e := range exposures {
+		e := e
+		name := fmt.Sprintf("%s-MS-GK-Matched-Mc-Nemar", e.Covariate.Name)
+		if exposed, ok := reg.exposed[name]; ok {
+			if exposed != e.Exposed {
+				return fmt.Errorf("%s already counts %s=%s as exposed", name, e.Covariate.Name, numberStr(exposed))
+			}
+			continue
+		}
+		if reg.exposed == nil {
+			reg.exposed = map[string]float64{}
+		}
+		reg.exposed[name] = e.Exposed
+		description := fmt.Sprintf("McNemar test of %s=%s in the matched pairs, needs 1:1 sets without replacement", e.Covariate.Name, numberStr(e.Exposed))
+		reg.Register(NewReport(name, description, []Input{MatchedInput}, func(w *csv.Writer, in *Inputs) error {
+			pairs, err := in.Matched.IndependentPairs()
+			if err != nil {
+				return err
+			}
+			m, err := NewMcNemar(pairs, e)
+			if err != nil {
+				return err
+			}
+			return WriteMcNemar(w, m)
+		}))
+	}
+	return nil
+}
+
+// RegisterTables adds a contingency table of all and of the matched subjects
+// for each pair of -tables.
+func (reg *Registry) RegisterTables(tables [][2]Category) {
+	for _, t := range tables {
+		left, top := t[0], t[1]
+		name := fmt.Sprintf("Tabelle-%s-%s", left.Name, top.Name)
+		if reg.Lookup(name+"-Unmatched") != nil {
+			continue
+		}
+		reg.RegisterContingency(name+"-Unmatched", fmt.Sprintf("%s by %s, all subjects", left.Name, top.Name), []Input{SubjectsInput}, func(in *Inputs) Contingency {
+			return NewContingency(CategoryLevels(top, in.Subjects), CategoryLevels(left, in.Subjects), CategoryContingencySubjects(in.Subjects, left, top, nil))
+		})
+		reg.RegisterContingency(name+"-Matched", fmt.Sprintf("%s by %s, matched subjects", left.Name, top.Name), []Input{SubjectsInput, MatchedInput}, func(in *Inputs) Contingency {
+			return NewContingency(CategoryLevels(top, in.Subjects), CategoryLevels(left, in.Subjects), CategoryContingencySubjects(in.Matched.Unique(), left, top, in.Matched.Weights()))
+		})
+	}
+}
+
+// RegisterGroupValues adds a report of the covariate values per category
+// level for each pair of -group-values.
+func (reg *Registry) RegisterGroupValues(groupValues []groupValues) {
+	for _, g := range groupValues {
+		c, group := g.Covariate, g.Group
+		name := fmt.Sprintf("Werte-%s-%s", c.Name, group.Name)
+		if reg.Lookup(name) != nil {
+			continue
+		}
+		reg.RegisterGroups(name, fmt.Sprintf("%s by %s", c.Name, group.Name), []Input{SubjectsInput}, func(in *Inputs) ([]Group, []GroupSubject) {
+			return CovariateGroups(c, group, in.Subjects)
+		})
+	}
+}
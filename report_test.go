@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Registry_Select(t *testing.T) {
+	reg := &Registry{}
+	for _, name := range []string{"C", "A", "B"} {
+		reg.Register(NewReport(name, "", nil, func(w *csv.Writer, in *Inputs) error {
+			return nil
+		}))
+	}
+	tests := []struct {
+		Only    []string
+		Exclude []string
+		Want    []string
+		WantErr bool
+	}{
+		{Want: []string{"C", "A", "B"}},
+		{Only: []string{"B", "C"}, Want: []string{"C", "B"}},
+		{Exclude: []string{"A"}, Want: []string{"C", "B"}},
+		{Only: []string{"A", "B"}, Exclude: []string{"B"}, Want: []string{"A"}},
+		{Only: []string{"D"}, WantErr: true},
+		{Exclude: []string{"D"}, WantErr: true},
+	}
+	for i, test := range tests {
+		reports, err := reg.Select(test.Only, test.Exclude)
+		if (err != nil) != test.WantErr {
+			t.Errorf("test %d: got err %v", i, err)
+			continue
+		}
+		var got []string
+		for _, r := range reports {
+			got = append(got, r.Name())
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("test %d: got %v, want %v", i, got, test.Want)
+		}
+	}
+}
+
+func Test_DefaultReports(t *testing.T) {
+	// DefaultReports panics on duplicate names.
+	reports := DefaultReports().Reports()
+	if len(reports) == 0 {
+		t.Fatal("no reports")
+	}
+	in := &Inputs{}
+	needed := NeededInputs(reports)
+	for _, i := range []Input{SubjectsInput, MatchedInput, MSMatchedInput, StabilityInput, PropensityInput, MSPropensityInput} {
+		if !needed[i] {
+			t.Errorf("no report needs %s", i)
+		}
+	}
+	if missing, ok := in.Missing(DefaultReports().Lookup("Propensity-Score-Modell")); !ok || missing != PropensityInput {
+		t.Errorf("got %s, %t", missing, ok)
+	}
+	if _, ok := in.Missing(DefaultReports().Lookup("Patienten")); ok {
+		t.Errorf("want no missing input for Patienten")
+	}
+}
+
+func Test_ParseReportNames(t *testing.T) {
+	got := ParseReportNames(" Patienten, ,Balance")
+	if want := []string{"Patienten", "Balance"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func Test_RenderReport_noNames(t *testing.T) {
+	controls := testSubjects(GK, Female, 30, 50)
+	cases := testSubjects(RRMS, Female, 31, 32, 51)
+	subjects := append(append(Subjects{}, controls...), cases...)
+	var names []string
+	for i, s := range subjects {
+		s.ProbeNumber = fmt.Sprintf("%d", i+1)
+		s.FirstName = fmt.Sprintf("Vorname%d", i)
+		s.LastName = fmt.Sprintf("Nachname%d", i)
+		names = append(names, s.FirstName, s.LastName)
+	}
+	gender, _ := CategoryByName("Gender")
+	strata := Strata{gender}
+	// The control aged 50 only gets one of two cases, so its case is
+	// unmatched with a reason naming the control.
+	matched, unmatched := match(subjects, MatchOptions{Method: Greedy, Strata: strata, Ratio: 2, AgeCaliper: 5})
+	if err := AssignPseudonyms(subjects, SequentialPseudonyms, nil); err != nil {
+		t.Fatal(err)
+	}
+	StripIdentities(subjects)
+	in := &Inputs{
+		Subjects:  subjects,
+		Matched:   matched,
+		Unmatched: unmatched,
+		Strata:    strata,
+	}
+	var reason bool
+	for _, r := range DefaultReports().Reports() {
+		if _, ok := in.Missing(r); ok {
+			continue
+		}
+		records, _ := RenderReport(r, in, false)
+		for _, record := range records {
+			for _, cell := range record {
+				reason = reason || cell == "control P0002 had only 1 of 2 cases"
+				for _, name := range names {
+					if strings.Contains(cell, name) {
+						t.Errorf("%s: %q contains %s", r.Name(), cell, name)
+					}
+				}
+			}
+		}
+	}
+	if !reason {
+		t.Errorf("want the unmatched case to name its control by pseudonym")
+	}
+}
+
+func Test_Registry_SetEffect(t *testing.T) {
+	diagnosis, _ := CategoryByName("Diagnosis")
+	gender, _ := CategoryByName("Gender")
+	subjects := append(testSubjects(GK, Female, 30), testSubjects(RRMS, Male, 40)...)
+	tests := []struct {
+		Name    string
+		Effect  Effect
+		WantErr bool
+	}{
+		{Name: "IgG-MS-GK-Matched", Effect: Effect{Exposed: "GK", Outcome: "negativ"}},
+		{Name: "IgG-Treatment", Effect: Effect{Exposed: "Positiv", Outcome: string(Untreated)}, WantErr: true},
+		{Name: "IgG-MS-GK-Matched", Effect: Effect{Exposed: "GK", Outcome: "vielleicht"}, WantErr: true},
+		{Name: "Tabelle-Gender-Diagnosis-Matched", Effect: Effect{Exposed: "RRMS", Outcome: "w"}},
+		{Name: "Tabelle-Gender-Diagnosis-Unmatched", Effect: Effect{Exposed: "SPMS", Outcome: "w"}, WantErr: true},
+		{Name: "Patienten-Matched", Effect: Effect{Exposed: "MS", Outcome: "positiv"}, WantErr: true},
+	}
+	for i, test := range tests {
+		reg := DefaultReports()
+		reg.RegisterTables([][2]Category{{gender, diagnosis}})
+		err := reg.SetEffect(test.Name, test.Effect, subjects)
+		if (err != nil) != test.WantErr {
+			t.Errorf("test %d: want error %t, got %v", i, test.WantErr, err)
+		}
+	}
+}
+
+func Test_Registry_RegisterFlagReports(t *testing.T) {
+	reg := DefaultReports()
+	if err := reg.RegisterFlagReports("IgG:Diagnosis", "IgGTiter:Gender", "Nikotinabusus"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Tabelle-IgG-Diagnosis-Matched", "Werte-IgGTiter-Gender-Statistik", "Nikotinabusus-MS-GK-Matched-Mc-Nemar"} {
+		if reg.Lookup(name) == nil {
+			t.Errorf("want report %s", name)
+		}
+	}
+	for i, flags := range [][3]string{{"IgG", "", ""}, {"", "Foo:Gender", ""}, {"", "", "IgG=1"}} {
+		if err := DefaultReports().RegisterFlagReports(flags[0], flags[1], flags[2]); err == nil {
+			t.Errorf("test %d: want error", i)
+		}
+	}
+}
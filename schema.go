@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Variable is a subject property that is declared in the mapping instead of
+// being a field of Subject. Its values are kept in Subject.Values.
+type Variable struct {
+	Name string `json:"name"`
+	Column
+	// Type is one of status, nastatus, narelint, number, optional-number,
+	// yesno or category.
+	Type FieldType `json:"type"`
+	// Levels are the allowed values of a category, in the order reports
+	// list them. For the other types they default to the values the type
+	// can have.
+	Levels   []string `json:"levels,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// CategoryField is free text, restricted to the Levels of its Variable if
+// there are any. Empty cells are missing.
+const CategoryField FieldType = "category"
+
+var variableTypes = []FieldType{StatusField, NAStatusField, NARelIntField, NumberField, OptionalNumberField, YesNoField, CategoryField}
+
+// Value is the value of a Variable for one subject.
+type Value struct {
+	Text string
+	// Number is the value as used by covariates, e.g. 1 for positiv. It is
+	// only valid if HasNumber is true.
+	Number    float64
+	HasNumber bool
+}
+
+// Variables are the variables of the mapping in use, see RegisterVariables.
+var Variables []Variable
+
+// RegisterVariables makes vs available by name wherever categories and
+// covariates are looked up, e.g. for strata, propensity scores, balance and
+// the generic reports.
+func RegisterVariables(vs []Variable) {
+	Variables = vs
+	for _, v := range vs {
+		name := v.Name
+		Categories = append(Categories, Category{name, func(s *Subject) string {
+			return s.Values[name].Text
+		}})
+		if v.Type == CategoryField {
+			continue
+		}
+		Covariates = append(Covariates, Covariate{name, func(s *Subject) (float64, bool) {
+			val := s.Values[name]
+			return val.Number, val.HasNumber
+		}})
+	}
+}
+
+// levels returns the values v can have.
+func (v Variable) levels() []string {
+	if len(v.Levels) > 0 {
+		return v.Levels
+	}
+	switch v.Type {
+	case StatusField:
+		return []string{"positiv", "negativ"}
+	case NAStatusField:
+		return []string{"positiv", "negativ", "n/a"}
+	case YesNoField:
+		return []string{string(Yes), string(No), string(NA)}
+	}
+	return nil
+}
+
+func (v Variable) validate() error {
+	if v.Name == "" {
+		return fmt.Errorf("variable without name")
+	}
+	if v.Column.Column == "" {
+		return fmt.Errorf("no column for variable %s", v.Name)
+	}
+	if subjectField(&Subject{}, v.Name) != nil {
+		return fmt.Errorf("variable %s is a Subject field", v.Name)
+	}
+	if _, err := CategoryByName(v.Name); err == nil {
+		return fmt.Errorf("variable %s is already a category", v.Name)
+	}
+	if _, err := CovariateByName(v.Name); err == nil {
+		return fmt.Errorf("variable %s is already a covariate", v.Name)
+	}
+	for _, t := range variableTypes {
+		if v.Type == t {
+			if len(v.Levels) > 0 && t != CategoryField {
+				return fmt.Errorf("levels of variable %s: only categories have levels", v.Name)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("type %q not allowed for variable %s", v.Type, v.Name)
+}
+
+// parse parses the text of a cell.
+func (v Variable) parse(val string) (Value, error) {
+	val = strings.TrimSpace(val)
+	switch v.Type {
+	case CategoryField:
+		if val == "" || len(v.Levels) == 0 {
+			return Value{Text: val}, nil
+		}
+		for _, l := range v.Levels {
+			if l == val {
+				return Value{Text: val}, nil
+			}
+		}
+		return Value{}, fmt.Errorf("Invalid %s: %s", v.Name, val)
+	case StatusField:
+		var s Status
+		if err := parseValue(v.Type, &s, val); err != nil {
+			return Value{}, err
+		}
+		return Value{Text: s.String(), Number: binary(bool(s)), HasNumber: true}, nil
+	case NAStatusField:
+		var s NAStatus
+		if err := parseValue(v.Type, &s, val); err != nil {
+			return Value{}, err
+		}
+		n, ok := naStatusValue(s)
+		return Value{Text: s.String(), Number: n, HasNumber: ok}, nil
+	case YesNoField:
+		var y YesNoNA
+		if err := parseValue(v.Type, &y, val); err != nil {
+			return Value{}, err
+		}
+		return Value{Text: string(y), Number: binary(y == Yes), HasNumber: y != NA}, nil
+	case NARelIntField:
+		var r NARelInt
+		if err := parseValue(v.Type, &r, val); err != nil {
+			return Value{}, err
+		}
+		exact := !r.NA() && r.Kind() == Eq
+		return Value{Text: r.String(), Number: float64(r.Val()), HasNumber: exact}, nil
+	case NumberField:
+		var f float64
+		if err := parseValue(v.Type, &f, val); err != nil {
+			return Value{}, err
+		}
+		return Value{Text: numberStr(f), Number: f, HasNumber: true}, nil
+	case OptionalNumberField:
+		var f *float64
+		if err := parseValue(v.Type, &f, val); err != nil {
+			return Value{}, err
+		}
+		if f == nil {
+			return Value{Text: "n/a"}, nil
+		}
+		return Value{Text: numberStr(*f), Number: *f, HasNumber: true}, nil
+	}
+	return Value{}, fmt.Errorf("Bad variable type: %s", v.Type)
+}
+
+// expected describes the values v accepts.
+func (v Variable) expected() string {
+	if v.Type == CategoryField && len(v.Levels) > 0 {
+		return strings.Join(v.Levels, ", ") + " oder leer"
+	}
+	if v.Type == CategoryField {
+		return "Text"
+	}
+	return v.Type.Expected()
+}
+
+func variableByName(name string) (Variable, bool) {
+	for _, v := range Variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variable{}, false
+}
+
+// CategoryLevels returns the values of c in report order: the levels of a
+// variable, or the values found in subjects, sorted.
+func CategoryLevels(c Category, subjects []*Subject) []string {
+	if v, ok := variableByName(c.Name); ok && v.levels() != nil {
+		return v.levels()
+	}
+	seen := map[string]bool{}
+	var levels []string
+	for _, s := range subjects {
+		if val := c.Value(s); !seen[val] {
+			seen[val] = true
+			levels = append(levels, val)
+		}
+	}
+	sort.Strings(levels)
+	return levels
+}
+
+// ParseCategoryPairs parses a comma separated list of name:name pairs, e.g.
+// "IgG:Diagnosis,ANA:Nikotinabusus".
+func ParseCategoryPairs(val string) ([][2]Category, error) {
+	var pairs [][2]Category
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		names := strings.SplitN(part, ":", 2)
+		if len(names) != 2 {
+			return nil, fmt.Errorf("Bad category pair: %s", part)
+		}
+		var pair [2]Category
+		for i, name := range names {
+			c, err := CategoryByName(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			pair[i] = c
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// CategoryContingencySubjects counts subjects by the levels of left and top.
+func CategoryContingencySubjects(subjects []*Subject, left, top Category, weights Weights) []ContingencySubject {
+	r := []ContingencySubject{}
+	for _, s := range subjects {
+		r = append(r, weights.weighted(s, categoryContingencySubject{s, left, top}))
+	}
+	return r
+}
+
+type categoryContingencySubject struct {
+	*Subject
+	left, top Category
+}
+
+func (s categoryContingencySubject) Top() string {
+	return s.top.Value(s.Subject)
+}
+
+func (s categoryContingencySubject) Left() string {
+	return s.left.Value(s.Subject)
+}
+
+// CovariateGroups returns the levels of group and the subjects with a value
+// for c, to list the values in one column per level like the IgG-Titer
+// reports. Subjects without a value are left out.
+func CovariateGroups(c Covariate, group Category, subjects []*Subject) ([]Group, []GroupSubject) {
+	var groups []Group
+	for _, l := range CategoryLevels(group, subjects) {
+		groups = append(groups, Level(l))
+	}
+	var groupSubjects []GroupSubject
+	for _, s := range subjects {
+		if _, ok := c.Value(s); ok {
+			groupSubjects = append(groupSubjects, covariateGroupSubject{s, c, group})
+		}
+	}
+	return groups, groupSubjects
+}
+
+// Level is a value of a category, used as a Group.
+type Level string
+
+func (l Level) String() string {
+	return string(l)
+}
+
+type covariateGroupSubject struct {
+	*Subject
+	covariate Covariate
+	group     Category
+}
+
+func (s covariateGroupSubject) String() string {
+	v, _ := s.covariate.Value(s.Subject)
+	return fmt.Sprintf("%f", v)
+}
+
+func (s covariateGroupSubject) Value() float64 {
+	v, _ := s.covariate.Value(s.Subject)
+	return v
+}
+
+func (s covariateGroupSubject) Group() Group {
+	return Level(s.group.Value(s.Subject))
+}
+
+type groupValues struct {
+	Covariate Covariate
+	Group     Category
+}
+
+// parseGroupValues parses a comma separated list of covariate:category pairs.
+func parseGroupValues(val string) ([]groupValues, error) {
+	var r []groupValues
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		names := strings.SplitN(part, ":", 2)
+		if len(names) != 2 {
+			return nil, fmt.Errorf("Bad covariate:category pair: %s", part)
+		}
+		c, err := CovariateByName(strings.TrimSpace(names[0]))
+		if err != nil {
+			return nil, err
+		}
+		group, err := CategoryByName(strings.TrimSpace(names[1]))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, groupValues{c, group})
+	}
+	return r, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func Test_Variable_parse(t *testing.T) {
+	tests := []struct {
+		Variable Variable
+		Val      string
+		Want     Value
+		Err      bool
+	}{
+		{Variable: Variable{Type: StatusField}, Val: "positiv", Want: Value{Text: "positiv", Number: 1, HasNumber: true}},
+		{Variable: Variable{Type: StatusField}, Val: "", Err: true},
+		{Variable: Variable{Type: NAStatusField}, Val: "keine angabe", Want: Value{Text: "n/a"}},
+		{Variable: Variable{Type: YesNoField}, Val: "Nein", Want: Value{Text: "nein", Number: 0, HasNumber: true}},
+		{Variable: Variable{Type: NARelIntField}, Val: "4", Want: Value{Text: "4", Number: 4, HasNumber: true}},
+		{Variable: Variable{Type: NARelIntField}, Val: "<6", Want: Value{Text: "<6", Number: 6}},
+		{Variable: Variable{Type: NumberField}, Val: "1,5", Want: Value{Text: "1.5", Number: 1.5, HasNumber: true}},
+		{Variable: Variable{Type: NumberField}, Val: "n/a", Err: true},
+		{Variable: Variable{Type: OptionalNumberField}, Val: "n/a", Want: Value{Text: "n/a"}},
+		{Variable: Variable{Type: CategoryField, Levels: []string{"A", "B"}}, Val: "B", Want: Value{Text: "B"}},
+		{Variable: Variable{Type: CategoryField, Levels: []string{"A", "B"}}, Val: "", Want: Value{}},
+		{Variable: Variable{Name: "HLA", Type: CategoryField, Levels: []string{"A", "B"}}, Val: "C", Err: true},
+	}
+	for i, test := range tests {
+		got, err := test.Variable.parse(test.Val)
+		if test.Err {
+			if err == nil {
+				t.Errorf("test %d: want error, got %v", i, got)
+			}
+			continue
+		}
+		if err != nil || got != test.Want {
+			t.Errorf("test %d: want %v, got %v (%v)", i, test.Want, got, err)
+		}
+	}
+}
+
+func Test_RegisterVariables(t *testing.T) {
+	categories, covariates := Categories, Covariates
+	defer func() {
+		Categories, Covariates, Variables = categories, covariates, nil
+	}()
+	mapping := Mapping{
+		Key: []string{"ProbeNumber"},
+		Fields: []FieldMapping{
+			{Field: "ProbeNumber", Column: Column{Column: "Probennummer"}, Type: StringField, Required: true},
+			{Field: "Diagnosis", Column: Column{Column: "Gruppe"}, Type: DiagnosisField, Required: true},
+		},
+		Variables: []Variable{
+			{Name: "HLA", Column: Column{Column: "HLA-DRB1*15:01"}, Type: StatusField, Required: true},
+			{Name: "Zentrum", Column: Column{Column: "Zentrum", Aliases: []string{"Standort"}}, Type: CategoryField, Levels: []string{"Berlin", "Hamburg"}},
+		},
+	}
+	if err := mapping.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	RegisterVariables(mapping.Variables)
+	if err := mapping.Validate(); err == nil {
+		t.Errorf("want clash with the registered variables")
+	}
+	rows := [][]string{
+		{"Probennummer", "Gruppe", "HLA-DRB1*15:01", "Standort"},
+		{"1", "GK", "positiv", "Berlin"},
+		{"2", "RRMS", "positiv", "Hamburg"},
+		{"3", "RRMS", "negativ", "Hamburg"},
+		{"4", "RRMS", "vielleicht", "Köln"},
+	}
+	subjects, _, err := parseSubjects(rows, mapping)
+	if errs, ok := err.(ValidationErrors); !ok || len(errs) != 2 {
+		t.Errorf("want 2 validation errors, got %v", err)
+	}
+	if len(subjects) != 3 {
+		t.Fatalf("want 3 subjects, got %d", len(subjects))
+	}
+	hla, err := CovariateByName("HLA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := hla.Value(subjects[2]); !ok || v != 0 {
+		t.Errorf("want HLA 0, got %f", v)
+	}
+	if _, err := CovariateByName("Zentrum"); err == nil {
+		t.Errorf("want categories without numeric value to be no covariate")
+	}
+	strata, err := ParseStrata("Zentrum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := strata.Key(subjects[1]); key != "Hamburg" {
+		t.Errorf("want stratum Hamburg, got %s", key)
+	}
+	pairs, err := ParseCategoryPairs("HLA:Zentrum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, top := pairs[0][0], pairs[0][1]
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := WriteContingency(w, CategoryLevels(top, subjects), CategoryLevels(left, subjects), CategoryContingencySubjects(subjects, left, top, nil)); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	want := "Title,Berlin,Hamburg\npositiv,1,1\nnegativ,0,1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+
+	"github.com/bradfitz/slice"
+)
+
+// Shuffled returns the subjects in an order that only depends on seed, not
+// on the order of the input file. They are sorted by their identifiers
+// first and then shuffled with a random source seeded by seed. A seed of 0
+// returns s unchanged.
+func (s Subjects) Shuffled(seed int64) Subjects {
+	if seed == 0 {
+		return s
+	}
+	shuffled := append(Subjects{}, s...)
+	slice.Sort(shuffled, func(i, j int) bool {
+		return subjectLess(shuffled[i], shuffled[j])
+	})
+	r := rand.New(rand.NewSource(seed))
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled
+}
+
+// stabilitySeeds returns the seeds of n stability runs after the one with
+// seed. 0 is skipped, because it does not shuffle.
+func stabilitySeeds(seed int64, n int) []int64 {
+	var seeds []int64
+	for next := seed + 1; len(seeds) < n; next++ {
+		if next != 0 {
+			seeds = append(seeds, next)
+		}
+	}
+	return seeds
+}
+
+// subjectLess orders subjects by their identifiers.
+func subjectLess(a, b *Subject) bool {
+	if a.ProbeNumber != b.ProbeNumber {
+		return a.ProbeNumber < b.ProbeNumber
+	}
+	if a.LabBerlinNumber != b.LabBerlinNumber {
+		return a.LabBerlinNumber < b.LabBerlinNumber
+	}
+	if a.LastName != b.LastName {
+		return a.LastName < b.LastName
+	}
+	return a.FirstName < b.FirstName
+}
+
+// PairStability counts how often each pair of subjects is formed across
+// repeated matching runs with different seeds.
+type PairStability struct {
+	Runs   int
+	pairs  [][2]*Subject
+	counts map[[2]*Subject]int
+}
+
+// Add records the pairs of one run.
+func (p *PairStability) Add(pairs [][2]*Subject) {
+	if p.counts == nil {
+		p.counts = map[[2]*Subject]int{}
+	}
+	p.Runs++
+	for _, pair := range pairs {
+		if p.counts[pair] == 0 {
+			p.pairs = append(p.pairs, pair)
+		}
+		p.counts[pair]++
+	}
+}
+
+// Write writes every pair seen in any run with the share of runs it was
+// formed in, most stable pairs first. The pairs of the reference run are
+// flagged.
+func (p *PairStability) Write(w *csv.Writer, labels [2]string, reference [][2]*Subject) error {
+	header := []string{labels[0], labels[1], "Runs", "Anteil", "Im Ergebnis"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	inReference := map[[2]*Subject]bool{}
+	for _, pair := range reference {
+		inReference[pair] = true
+	}
+	order := map[[2]*Subject]int{}
+	for i, pair := range p.pairs {
+		order[pair] = i
+	}
+	pairs := append([][2]*Subject{}, p.pairs...)
+	slice.Sort(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if p.counts[a] != p.counts[b] {
+			return p.counts[a] > p.counts[b]
+		}
+		return order[a] < order[b]
+	})
+	for _, pair := range pairs {
+		row := []string{
+			pair[0].String(),
+			pair[1].String(),
+			fmt.Sprintf("%d", p.counts[pair]),
+			fmt.Sprintf("%.2f", float64(p.counts[pair])/float64(p.Runs)),
+			yesNo(inReference[pair]),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pairKeys returns the control/case pairs of m in the form used by
+// PairStability.
+func (m MatchedSets) pairKeys() [][2]*Subject {
+	var keys [][2]*Subject
+	for _, pair := range m.Pairs() {
+		keys = append(keys, [2]*Subject{pair.Control, pair.Case})
+	}
+	return keys
+}
+
+// matchKeys returns the pairs formed by Subjects.Match in the form used by
+// PairStability. Each pair is ordered by subjectLess, so it doesn't depend on
+// which subject came first in the shuffled input.
+func matchKeys(matches []Match) [][2]*Subject {
+	var keys [][2]*Subject
+	for _, m := range matches {
+		if subjectLess(m.B, m.A) {
+			keys = append(keys, [2]*Subject{m.B, m.A})
+		} else {
+			keys = append(keys, [2]*Subject{m.A, m.B})
+		}
+	}
+	return keys
+}
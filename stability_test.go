@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func Test_stabilitySeeds(t *testing.T) {
+	tests := []struct {
+		Seed int64
+		N    int
+		Want []int64
+	}{
+		{Seed: 0, N: 3, Want: []int64{1, 2, 3}},
+		{Seed: -1, N: 2, Want: []int64{1, 2}},
+		{Seed: -3, N: 3, Want: []int64{-2, -1, 1}},
+		{Seed: 5, N: 0, Want: nil},
+	}
+	for i, test := range tests {
+		if got := stabilitySeeds(test.Seed, test.N); !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("test %d: want %v, got %v", i, test.Want, got)
+		}
+	}
+}
+
+func Test_PairStability(t *testing.T) {
+	a, b, c, d := &Subject{stripped: true, Pseudonym: "A"}, &Subject{stripped: true, Pseudonym: "B"}, &Subject{stripped: true, Pseudonym: "C"}, &Subject{stripped: true, Pseudonym: "D"}
+	tests := []struct {
+		Runs      [][][2]*Subject
+		Reference [][2]*Subject
+		Want      [][]string
+	}{
+		{
+			Runs:      [][][2]*Subject{{{a, b}}},
+			Reference: [][2]*Subject{{a, b}},
+			Want:      [][]string{{"A", "B", "1", "1.00", "yes"}},
+		},
+		{
+			Runs: [][][2]*Subject{
+				{{a, b}, {c, d}},
+				{{a, d}, {c, b}},
+				{{a, b}, {c, d}},
+				{{a, b}, {c, b}},
+			},
+			Reference: [][2]*Subject{{a, b}, {c, d}},
+			Want: [][]string{
+				{"A", "B", "3", "0.75", "yes"},
+				{"C", "D", "2", "0.50", "yes"},
+				{"C", "B", "2", "0.50", "no"},
+				{"A", "D", "1", "0.25", "no"},
+			},
+		},
+		{
+			// A run without pairs still counts towards the share.
+			Runs:      [][][2]*Subject{{{a, b}}, nil, {{a, b}}},
+			Reference: nil,
+			Want:      [][]string{{"A", "B", "2", "0.67", "no"}},
+		},
+	}
+	for i, test := range tests {
+		var p PairStability
+		for _, run := range test.Runs {
+			p.Add(run)
+		}
+		if p.Runs != len(test.Runs) {
+			t.Errorf("test %d: want %d runs, got %d", i, len(test.Runs), p.Runs)
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := p.Write(w, [2]string{"Kontrolle", "Fall"}, test.Reference); err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		w.Flush()
+		got, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		want := append([][]string{{"Kontrolle", "Fall", "Runs", "Anteil", "Im Ergebnis"}}, test.Want...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d: want %v, got %v", i, want, got)
+		}
+	}
+}
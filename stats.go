@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// meanVariance returns the mean and the sample variance of vals. Both are NaN
+// if there are not enough values.
+func meanVariance(vals []float64) (mean, variance float64) {
+	return weightedMeanVariance(vals, nil)
+}
+
+// weightedMeanVariance is meanVariance with frequency weights, i.e. a value
+// with a weight of 2 counts as if it appeared twice. Nil weights give every
+// value a weight of 1.
+func weightedMeanVariance(vals, weights []float64) (mean, variance float64) {
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+	var total float64
+	for i, v := range vals {
+		mean += weight(i) * v
+		total += weight(i)
+	}
+	if total == 0 {
+		return math.NaN(), math.NaN()
+	}
+	mean /= total
+	if total <= 1 {
+		return mean, math.NaN()
+	}
+	for i, v := range vals {
+		variance += weight(i) * (v - mean) * (v - mean)
+	}
+	variance /= total - 1
+	return mean, variance
+}
+
+// quantile returns the q-quantile of vals using linear interpolation between
+// the closest ranks. It returns NaN if vals is empty.
+func quantile(vals []float64, q float64) float64 {
+	if len(vals) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64{}, vals...)
+	sort.Float64s(sorted)
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// normalPValue returns the two-sided p-value of the standard normal
+// statistic z.
+func normalPValue(z float64) float64 {
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// chiSquarePValue returns the probability that a chi-square distributed
+// variable with df degrees of freedom is at least x.
+func chiSquarePValue(x float64, df int) float64 {
+	if math.IsNaN(x) || df <= 0 {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return 1
+	}
+	return regularizedGammaQ(float64(df)/2, x/2)
+}
+
+// regularizedGammaQ returns the upper regularized incomplete gamma function
+// Q(a, x), using the series for x < a+1 and the continued fraction
+// otherwise.
+func regularizedGammaQ(a, x float64) float64 {
+	const (
+		maxIter = 1000
+		eps     = 1e-15
+	)
+	lgammaA, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a*math.Log(x) - lgammaA)
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < maxIter; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*eps {
+				break
+			}
+		}
+		return 1 - prefix*sum
+	}
+	// Modified Lentz's method.
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIter; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < eps {
+			break
+		}
+	}
+	return prefix * h
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// ValidationError is a problem with a single cell of the input.
+type ValidationError struct {
+	// Line is the line of the text export or the row of the sheet,
+	// starting at 1.
+	Line   int
+	Column string
+	// Field is the subject field of the column, if it is mapped to one.
+	Field    string
+	Value    string
+	Expected string
+	Message  string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("line %d, column %s: %s", e.Line, e.Column, e.Message)
+}
+
+// ValidationErrors are all problems found in the input.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d validation errors, first: %s", len(e), e[0])
+}
+
+var validationHeader = []string{"Zeile", "Spalte", "Wert", "Erwartet", "Fehler"}
+
+func (e ValidationError) row() []string {
+	return []string{fmt.Sprintf("%d", e.Line), e.Column, e.Value, e.Expected, e.Message}
+}
+
+// WriteTable writes the errors as an aligned table for the terminal.
+func (e ValidationErrors) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(validationHeader, "\t"))
+	for _, err := range e {
+		fmt.Fprintln(tw, strings.Join(err.row(), "\t"))
+	}
+	return tw.Flush()
+}
+
+// Write writes one row per error.
+func (e ValidationErrors) Write(w *csv.Writer) error {
+	if err := w.Write(validationHeader); err != nil {
+		return err
+	}
+	for _, err := range e {
+		if err := w.Write(err.row()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redact returns the errors with the values of the identifyingFields
+// removed, also from the messages.
+func (e ValidationErrors) Redact() ValidationErrors {
+	redacted := make(ValidationErrors, len(e))
+	for i, err := range e {
+		if identifyingFields[err.Field] {
+			if err.Value != "" {
+				err.Message = strings.Replace(err.Message, err.Value, "***", -1)
+			}
+			err.Value = ""
+			err.Expected = ""
+		}
+		redacted[i] = err
+	}
+	return redacted
+}
+
+// WriteFile writes the errors as a CSV file to path, creating its directory
+// if needed.
+func (e ValidationErrors) WriteFile(path string) error {
+	return writeCSVFile(path, 0666, e.Write)
+}
+
+// writeCSVFile writes a CSV file with the given permissions, creating its
+// directory if needed.
+func writeCSVFile(path string, perm os.FileMode, fn func(*csv.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := fn(w); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Expected describes the values t accepts.
+func (t FieldType) Expected() string {
+	switch t {
+	case StringField:
+		return "Text"
+	case StatusField:
+		return "positiv oder negativ"
+	case NAStatusField:
+		return "positiv, negativ, keine angabe oder leer"
+	case DiagnosisField:
+		var names []string
+		for _, d := range Diagnoses {
+			names = append(names, string(d))
+		}
+		return strings.Join(names, ", ")
+	case GenderField:
+		var names []string
+		for _, g := range Genders {
+			names = append(names, string(g))
+		}
+		return strings.Join(names, ", ")
+	case YesNoField:
+		return "ja oder nein, sonst n/a"
+	case NARelIntField:
+		return "ganze Zahl, auch <n, <=n, >n, >=n, keine angabe oder leer"
+	case NumberField:
+		return "Zahl, z.B. 12,5"
+	case OptionalNumberField:
+		return "Zahl, z.B. 12,5, sonst n/a"
+	case DateField:
+		return "Datum TT.MM.JJJJ, Excel-Seriennummer oder leer"
+	case OptionalDateField:
+		return "Datum TT.MM.JJJJ, Excel-Seriennummer, sonst unbekannt"
+	}
+	return string(t)
+}
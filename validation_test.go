@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseSubjects_ValidationErrors(t *testing.T) {
+	mapping := Mapping{
+		Key: []string{"ProbeNumber"},
+		Fields: []FieldMapping{
+			{Field: "ProbeNumber", Column: Column{Column: "Probennummer"}, Type: StringField, Required: true},
+			{Field: "Age", Column: Column{Column: "Alter (PE)"}, Type: NumberField, Required: true},
+			{Field: "Gender", Column: Column{Column: "Geschlecht"}, Type: GenderField, Required: true},
+		},
+	}
+	tests := []struct {
+		Rows     [][]string
+		Subjects int
+		Want     ValidationErrors
+	}{
+		{
+			Rows: [][]string{
+				{"Probennummer", "Alter (PE)", "Geschlecht"},
+				{"1", "30", "w"},
+				{"2", "dreißig", "x"},
+				{"3", "40", "m"},
+				{"4", "", "m"},
+			},
+			Subjects: 2,
+			Want: ValidationErrors{
+				{Line: 3, Column: "Alter (PE)", Field: "Age", Value: "dreißig", Expected: "Zahl, z.B. 12,5", Message: "Bad number: dreißig"},
+				{Line: 3, Column: "Geschlecht", Field: "Gender", Value: "x", Expected: "m, w", Message: "Invalid Gender: x"},
+				{Line: 5, Column: "Alter (PE)", Field: "Age", Value: "", Expected: "Zahl, z.B. 12,5", Message: "Bad number: "},
+			},
+		},
+		{
+			Rows: [][]string{
+				{"Titel"},
+				{"Probennummer"},
+				{"1"},
+			},
+			Want: ValidationErrors{
+				{Line: 2, Column: "Alter (PE)", Expected: "Spalte Alter (PE)", Message: "Unknown column: Alter (PE)"},
+				{Line: 2, Column: "Geschlecht", Expected: "Spalte Geschlecht", Message: "Unknown column: Geschlecht"},
+			},
+		},
+	}
+	for i, test := range tests {
+		subjects, _, err := parseSubjects(test.Rows, mapping)
+		if len(subjects) != test.Subjects {
+			t.Errorf("test %d: want %d valid subjects, got %d", i, test.Subjects, len(subjects))
+		}
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Errorf("test %d: want ValidationErrors, got %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(errs, test.Want) {
+			t.Errorf("test %d: want %#v, got %#v", i, test.Want, errs)
+		}
+	}
+}
+
+func Test_ValidationErrors_Redact(t *testing.T) {
+	mapping := Mapping{
+		Key: []string{"ProbeNumber"},
+		Fields: []FieldMapping{
+			{Field: "ProbeNumber", Column: Column{Column: "Patienten-ID"}, Type: StringField, Required: true},
+			{Field: "SamplingDate", Column: Column{Column: "Entnahme"}, Type: DateField, Required: true},
+			{Field: "Age", Column: Column{Column: "Alter (PE)"}, Type: NumberField, Required: true},
+		},
+	}
+	rows := [][]string{
+		{"Patienten-ID", "Entnahme", "Alter (PE)"},
+		{"MU-4711", "31.02.2020", "x"},
+	}
+	_, _, err := parseSubjects(rows, mapping)
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("want 2 validation errors, got %v", err)
+	}
+	// An ID error, e.g. of CheckSubjects, under the renamed column.
+	errs = append(errs, ValidationError{Line: 2, Column: "Patienten-ID", Field: "ProbeNumber", Value: "MU-4711", Expected: "eindeutig", Message: "duplicate MU-4711"})
+	got := errs.Redact()
+	for _, i := range []int{0, 2} {
+		e := got[i]
+		if e.Value != "" || e.Expected != "" || strings.Contains(e.Message, "MU-4711") || strings.Contains(e.Message, "31.02.2020") {
+			t.Errorf("want %s redacted, got %+v", e.Column, e)
+		}
+	}
+	if !reflect.DeepEqual(got[1], errs[1]) {
+		t.Errorf("want %+v unchanged, got %+v", errs[1], got[1])
+	}
+	if errs[0].Value == "" {
+		t.Errorf("Redact changed its receiver")
+	}
+}
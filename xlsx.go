@@ -0,0 +1,286 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readXLSX returns the cells of a worksheet of an .xlsx workbook as text,
+// the way they would appear in a "Unicode text" export: numbers without
+// exponent, dates as dd.mm.yyyy. An empty sheet name selects the first
+// sheet. Missing cells are empty strings.
+func readXLSX(r io.ReaderAt, size int64, sheet string) ([][]string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+	var workbook xlsxWorkbook
+	if err := readXMLFile(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := readXMLFile(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	var sheetPath string
+	var names []string
+	for _, s := range workbook.Sheets {
+		names = append(names, s.Name)
+		if sheetPath != "" || (sheet != "" && s.Name != sheet) {
+			continue
+		}
+		for _, rel := range rels.Relationships {
+			if rel.ID == s.RelID {
+				sheetPath = rel.Target
+			}
+		}
+		if sheetPath == "" {
+			return nil, fmt.Errorf("Bad xlsx: no file for sheet %s", s.Name)
+		}
+	}
+	if sheetPath == "" {
+		return nil, fmt.Errorf("Unknown sheet: %s (available: %s)", sheet, strings.Join(names, ", "))
+	}
+	if strings.HasPrefix(sheetPath, "/") {
+		sheetPath = sheetPath[1:]
+	} else {
+		sheetPath = path.Join("xl", sheetPath)
+	}
+	var sst xlsxSharedStrings
+	if files["xl/sharedStrings.xml"] != nil {
+		if err := readXMLFile(files, "xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+	}
+	var styles xlsxStyles
+	if files["xl/styles.xml"] != nil {
+		if err := readXMLFile(files, "xl/styles.xml", &styles); err != nil {
+			return nil, err
+		}
+	}
+	var ws xlsxWorksheet
+	if err := readXMLFile(files, sheetPath, &ws); err != nil {
+		return nil, err
+	}
+	dateBase := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if workbook.Properties.Date1904 == "1" || workbook.Properties.Date1904 == "true" {
+		dateBase = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	var rows [][]string
+	for _, row := range ws.Rows {
+		index := len(rows)
+		if row.Ref != "" {
+			n, err := strconv.Atoi(row.Ref)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("Bad xlsx row: %s", row.Ref)
+			}
+			index = n - 1
+		}
+		for len(rows) <= index {
+			rows = append(rows, nil)
+		}
+		var cells []string
+		for _, c := range row.Cells {
+			col := len(cells)
+			if c.Ref != "" {
+				col, err = xlsxColumn(c.Ref)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			val, err := c.text(sst, styles, dateBase)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", c.Ref, err)
+			}
+			cells[col] = val
+		}
+		rows[index] = cells
+	}
+	return rows, nil
+}
+
+func readXMLFile(files map[string]*zip.File, name string, v interface{}) error {
+	f := files[name]
+	if f == nil {
+		return fmt.Errorf("Bad xlsx: missing %s", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("Bad xlsx: %s: %s", name, err)
+	}
+	return nil
+}
+
+// xlsxColumn returns the zero based column of a cell reference like "AB12".
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("Bad cell reference: %s", ref)
+	}
+	return col - 1, nil
+}
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 string `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name  string `xml:"name,attr"`
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxText is a plain or rich text string.
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	s := t.T
+	for _, r := range t.R {
+		s += r.T
+	}
+	return s
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// isDate returns true if the cell style with the given index formats numbers
+// as a date or time.
+func (s xlsxStyles) isDate(style int) bool {
+	if style < 0 || style >= len(s.CellXfs) {
+		return false
+	}
+	id := s.CellXfs[style].NumFmtID
+	if (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58) {
+		return true
+	}
+	for _, f := range s.NumFmts {
+		if f.ID == id {
+			return isDateFormatCode(f.Code)
+		}
+	}
+	return false
+}
+
+// isDateFormatCode returns true if a custom number format contains date or
+// time placeholders outside of literal text and [] sections.
+func isDateFormatCode(code string) bool {
+	var (
+		quoted  bool
+		bracket bool
+	)
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quoted:
+			quoted = c != '"'
+		case bracket:
+			bracket = c != ']'
+		case c == '"':
+			quoted = true
+		case c == '[':
+			bracket = true
+		case c == '\\' || c == '_' || c == '*':
+			i++
+		case strings.IndexByte("dmyhsDMYHS", c) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Ref   string     `xml:"r,attr"`
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"`
+	Style  int      `xml:"s,attr"`
+	Type   string   `xml:"t,attr"`
+	Value  string   `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+func (c xlsxCell) text(sst xlsxSharedStrings, styles xlsxStyles, dateBase time.Time) (string, error) {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.Value)
+		if err != nil || i < 0 || i >= len(sst.Items) {
+			return "", fmt.Errorf("Bad shared string: %s", c.Value)
+		}
+		return sst.Items[i].String(), nil
+	case "inlineStr":
+		return c.Inline.String(), nil
+	case "str", "e":
+		return c.Value, nil
+	case "b":
+		if c.Value == "1" {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+	if c.Value == "" {
+		return "", nil
+	}
+	f, err := strconv.ParseFloat(c.Value, 64)
+	if err != nil {
+		return "", fmt.Errorf("Bad number: %s", c.Value)
+	}
+	if !styles.isDate(c.Style) {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	seconds := math.Floor(f*86400 + 0.5)
+	t := dateBase.Add(time.Duration(seconds) * time.Second)
+	if math.Mod(seconds, 86400) == 0 {
+		return t.Format("02.01.2006"), nil
+	}
+	return t.Format("02.01.2006 15:04:05"), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testWorkbook returns an .xlsx file with the given sheets, each of which is
+// the XML of its sheetData.
+func testWorkbook(t *testing.T, sheets map[string]string, order []string) *bytes.Reader {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	files := map[string]string{
+		"xl/sharedStrings.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Probennummer</t></si>
+<si><t>Geburtsdatum</t></si>
+<si><r><t>Erkrankungs</t></r><r><t>dauer</t></r></si>
+<si><t>3,5</t></si>
+</sst>`,
+		"xl/styles.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="dd/mm/yyyy;@"/></numFmts>
+<cellXfs count="3"><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs>
+</styleSheet>`,
+	}
+	workbook := `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	rels := `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	for i, name := range order {
+		id := string(rune('1' + i))
+		workbook += `<sheet name="` + name + `" sheetId="` + id + `" r:id="rId` + id + `"/>`
+		rels += `<Relationship Id="rId` + id + `" Target="worksheets/sheet` + id + `.xml"/>`
+		files["xl/worksheets/sheet"+id+".xml"] = `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + sheets[name] + `</sheetData></worksheet>`
+	}
+	files["xl/workbook.xml"] = workbook + `</sheets></workbook>`
+	files["xl/_rels/workbook.xml.rels"] = rels + `</Relationships>`
+	for name, content := range files {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func Test_readXLSX(t *testing.T) {
+	sheets := map[string]string{
+		"Info": `<row r="1"><c r="A1" t="inlineStr"><is><t>nur Info</t></is></c></row>`,
+		"Daten": `<row r="1"><c r="A1" t="inlineStr"><is><t>Export vom Labor</t></is></c></row>
+<row r="3"><c r="A3" t="s"><v>0</v></c><c r="B3" t="s"><v>1</v></c><c r="D3" t="s"><v>2</v></c></row>
+<row r="4"><c r="A4"><v>17</v></c><c r="B4" s="1"><v>29221</v></c><c r="D4" t="s"><v>3</v></c></row>
+<row r="5"><c r="A5" t="str"><v>18a</v></c><c r="B5" s="2"><v>29221.5</v></c><c r="C5"><v>0.1</v></c><c r="D5"><v>1E-3</v></c></row>`,
+	}
+	order := []string{"Info", "Daten"}
+	tests := []struct {
+		Sheet string
+		Want  [][]string
+		Err   string
+	}{
+		{
+			Sheet: "",
+			Want:  [][]string{{"nur Info"}},
+		},
+		{
+			Sheet: "Daten",
+			Want: [][]string{
+				{"Export vom Labor"},
+				nil,
+				{"Probennummer", "Geburtsdatum", "", "Erkrankungsdauer"},
+				{"17", "01.01.1980", "", "3,5"},
+				{"18a", "01.01.1980 12:00:00", "0.1", "0.001"},
+			},
+		},
+		{
+			Sheet: "Tabelle1",
+			Err:   "Unknown sheet: Tabelle1 (available: Info, Daten)",
+		},
+	}
+	for i, test := range tests {
+		r := testWorkbook(t, sheets, order)
+		got, err := readXLSX(r, r.Size(), test.Sheet)
+		if test.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Err) {
+				t.Errorf("test %d: want error %q, got %v", i, test.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("test %d: want %q, got %q", i, test.Want, got)
+		}
+		if test.Sheet == "Daten" {
+			if header, err := findHeader(got, DefaultMapping.HeaderNames()); err != nil || header != 2 {
+				t.Errorf("test %d: want header row 2, got %d (%v)", i, header, err)
+			}
+		}
+	}
+}
+
+func Test_isDateFormatCode(t *testing.T) {
+	tests := []struct {
+		Code string
+		Want bool
+	}{
+		{"General", false},
+		{"0.00", false},
+		{`#,##0.00 "Tage"`, false},
+		{"[Red]0.00", false},
+		{"dd/mm/yyyy;@", true},
+		{"[$-407]dd. mmmm yyyy", true},
+		{"hh:mm", true},
+	}
+	for i, test := range tests {
+		if got := isDateFormatCode(test.Code); got != test.Want {
+			t.Errorf("test %d: %s: want %t, got %t", i, test.Code, test.Want, got)
+		}
+	}
+}